@@ -0,0 +1,115 @@
+package go_i2cp
+
+import (
+	"context"
+	"time"
+
+	"github.com/wkoomson/go-i2cp/message"
+)
+
+// BandwidthLimits are the limits the router reports in its BandwidthLimits
+// message, rates are in KBytes per second.
+type BandwidthLimits struct {
+	ClientInbound  uint32
+	ClientOutbound uint32
+	RouterInbound  uint32
+	// RouterInboundBurst is the inbound rate the router allows for
+	// RouterBurstTime.
+	RouterInboundBurst uint32
+	RouterOutbound     uint32
+	// RouterOutboundBurst is the outbound rate the router allows for
+	// RouterBurstTime.
+	RouterOutboundBurst uint32
+	RouterBurstTime     time.Duration
+}
+
+func newBandwidthLimits(msg *message.BandwidthLimits) BandwidthLimits {
+	return BandwidthLimits{
+		ClientInbound:       msg.ClientInbound,
+		ClientOutbound:      msg.ClientOutbound,
+		RouterInbound:       msg.RouterInbound,
+		RouterInboundBurst:  msg.RouterInboundBurst,
+		RouterOutbound:      msg.RouterOutbound,
+		RouterOutboundBurst: msg.RouterOutboundBurst,
+		RouterBurstTime:     time.Duration(msg.RouterBurstTime) * time.Second,
+	}
+}
+
+// ClientOutboundBytes returns the client outbound limit in bytes per second.
+func (l BandwidthLimits) ClientOutboundBytes() int64 {
+	return int64(l.ClientOutbound) * 1024
+}
+
+// ClientInboundBytes returns the client inbound limit in bytes per second.
+func (l BandwidthLimits) ClientInboundBytes() int64 {
+	return int64(l.ClientInbound) * 1024
+}
+
+// BandwidthLimits asks the router for its bandwidth limits and waits for the
+// answer until ctx is done. Concurrent calls share one request.
+func (c *Client) BandwidthLimits(ctx context.Context) (BandwidthLimits, error) {
+	if !c.IsConnected() {
+		return BandwidthLimits{}, ErrNotConnected
+	}
+	waiter := make(chan BandwidthLimits, 1)
+	c.lock.Lock()
+	first := len(c.bandwidthWaiters) == 0
+	c.bandwidthWaiters = append(c.bandwidthWaiters, waiter)
+	done := c.done
+	c.lock.Unlock()
+	if first {
+		if err := c.msgGetBandwidthLimits(true); err != nil {
+			c.cancelBandwidthLimits(waiter)
+			return BandwidthLimits{}, err
+		}
+	}
+	select {
+	case limits, ok := <-waiter:
+		if !ok {
+			return BandwidthLimits{}, ErrRouterDisconnected
+		}
+		return limits, nil
+	case <-done:
+		c.cancelBandwidthLimits(waiter)
+		return BandwidthLimits{}, ErrNotConnected
+	case <-ctx.Done():
+		c.cancelBandwidthLimits(waiter)
+		return BandwidthLimits{}, ctx.Err()
+	}
+}
+
+// LastBandwidthLimits returns the limits of the latest BandwidthLimits
+// message from the router, ok is false if none arrived yet.
+func (c *Client) LastBandwidthLimits() (limits BandwidthLimits, ok bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.bandwidthLimits == nil {
+		return
+	}
+	return *c.bandwidthLimits, true
+}
+
+func (c *Client) cancelBandwidthLimits(waiter chan BandwidthLimits) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for i, w := range c.bandwidthWaiters {
+		if w == waiter {
+			c.bandwidthWaiters = append(c.bandwidthWaiters[:i], c.bandwidthWaiters[i+1:]...)
+			return
+		}
+	}
+}
+
+func (c *Client) onMsgBandwithLimit(msg *message.BandwidthLimits) error {
+	Debug(TAG|PROTOCOL, "Received BandwidthLimits message.")
+	limits := newBandwidthLimits(msg)
+	c.lock.Lock()
+	c.bandwidthLimits = &limits
+	waiters := c.bandwidthWaiters
+	c.bandwidthWaiters = nil
+	c.lock.Unlock()
+	for _, waiter := range waiters {
+		waiter <- limits
+	}
+	return nil
+}
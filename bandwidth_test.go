@@ -0,0 +1,56 @@
+package go_i2cp
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClient_BandwidthLimits(t *testing.T) {
+	router, client := startRouter(t)
+	router.BandwidthLimits.ClientOutbound = 100
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := client.BandwidthLimits(ctx); err != ErrNotConnected {
+		t.Fatalf("Expected ErrNotConnected, got %v", err)
+	}
+	if err := client.ConnectContext(ctx); err != nil {
+		t.Fatalf("Could not connect: %s", err.Error())
+	}
+	defer client.Disconnect()
+	if _, ok := client.LastBandwidthLimits(); ok {
+		t.Fatal("Expected no bandwidth limits before asking the router")
+	}
+
+	expected := BandwidthLimits{
+		ClientInbound:       256,
+		ClientOutbound:      100,
+		RouterInbound:       512,
+		RouterInboundBurst:  768,
+		RouterOutbound:      512,
+		RouterOutboundBurst: 768,
+		RouterBurstTime:     20 * time.Second,
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			limits, err := client.BandwidthLimits(ctx)
+			if err != nil {
+				t.Errorf("Could not get bandwidth limits: %s", err.Error())
+			} else if limits != expected {
+				t.Errorf("Expected %+v, got %+v", expected, limits)
+			}
+		}()
+	}
+	wg.Wait()
+	limits, ok := client.LastBandwidthLimits()
+	if !ok || limits != expected {
+		t.Fatalf("Expected the latest limits %+v, got %+v", expected, limits)
+	}
+	if limits.ClientOutboundBytes() != 100*1024 {
+		t.Fatalf("Expected %d bytes per second, got %d", 100*1024, limits.ClientOutboundBytes())
+	}
+}
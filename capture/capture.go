@@ -0,0 +1,149 @@
+// Package capture reads and writes recordings of the I2CP frames exchanged
+// between a client and a router.
+//
+// A capture file starts with the 8 byte magic "I2CPCAP1", followed by one
+// record per frame: the direction byte, the time as 8 byte unix nanoseconds
+// and the frame as sent on the wire, a 4 byte body length, the message type
+// and the body. All integers are big endian.
+package capture
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/wkoomson/go-i2cp/message"
+)
+
+// Magic starts every capture file.
+const Magic = "I2CPCAP1"
+
+// Direction tells who sent a frame.
+type Direction uint8
+
+const (
+	ClientToRouter Direction = 1
+	RouterToClient Direction = 2
+)
+
+func (d Direction) String() string {
+	switch d {
+	case ClientToRouter:
+		return "client->router"
+	case RouterToClient:
+		return "router->client"
+	}
+	return fmt.Sprintf("direction(%d)", uint8(d))
+}
+
+// ErrBadMagic is returned by NewReader for input that isn't a capture file.
+var ErrBadMagic = errors.New("capture: not an i2cp capture file")
+
+// Record is a single captured frame.
+type Record struct {
+	Time      time.Time
+	Direction Direction
+	Type      uint8
+	Body      []byte
+}
+
+// Decode decodes the frame with the message package.
+func (r Record) Decode() (message.Message, error) {
+	return message.Decode(r.Type, r.Body)
+}
+
+// Writer writes a capture file, it is safe for concurrent use.
+type Writer struct {
+	lock sync.Mutex
+	w    io.Writer
+}
+
+// NewWriter writes the capture file header to w.
+func NewWriter(w io.Writer) (*Writer, error) {
+	if _, err := io.WriteString(w, Magic); err != nil {
+		return nil, err
+	}
+	return &Writer{w: w}, nil
+}
+
+// Write appends rec to the capture.
+func (w *Writer) Write(rec Record) error {
+	buf := make([]byte, 9+message.HeaderSize, 9+message.HeaderSize+len(rec.Body))
+	buf[0] = byte(rec.Direction)
+	binary.BigEndian.PutUint64(buf[1:9], uint64(rec.Time.UnixNano()))
+	binary.BigEndian.PutUint32(buf[9:13], uint32(len(rec.Body)))
+	buf[13] = rec.Type
+	buf = append(buf, rec.Body...)
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	_, err := w.w.Write(buf)
+	return err
+}
+
+// Reader reads records from a capture file.
+type Reader struct {
+	r *bufio.Reader
+}
+
+// NewReader checks the capture file header of r.
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(Magic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != Magic {
+		return nil, ErrBadMagic
+	}
+	return &Reader{r: br}, nil
+}
+
+// Next returns the next record, io.EOF after the last one.
+func (r *Reader) Next() (rec Record, err error) {
+	var header [9]byte
+	if _, err = io.ReadFull(r.r, header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = fmt.Errorf("capture: truncated record: %w", err)
+		}
+		return
+	}
+	rec.Direction = Direction(header[0])
+	rec.Time = time.Unix(0, int64(binary.BigEndian.Uint64(header[1:])))
+	if rec.Type, rec.Body, err = message.ReadFrame(r.r, message.MaxSize); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return rec, fmt.Errorf("capture: truncated record: %w", err)
+	}
+	return
+}
+
+// ReadAll returns every record of the capture in r.
+func ReadAll(r io.Reader) (records []Record, err error) {
+	var reader *Reader
+	if reader, err = NewReader(r); err != nil {
+		return
+	}
+	for {
+		var rec Record
+		if rec, err = reader.Next(); err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return
+		}
+		records = append(records, rec)
+	}
+}
+
+// ReadFile returns every record of the capture file name.
+func ReadFile(name string) ([]Record, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadAll(f)
+}
@@ -0,0 +1,43 @@
+package capture
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriterReader(t *testing.T) {
+	records := []Record{
+		{Time: time.Unix(0, 1500000000000000000), Direction: ClientToRouter, Type: 32, Body: []byte{6, '0', '.', '9', '.', '3', '3'}},
+		{Time: time.Unix(0, 1500000000000000001), Direction: RouterToClient, Type: 30, Body: []byte{}},
+	}
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rec := range records {
+		if err = w.Write(rec); err != nil {
+			t.Fatalf("Could not write record: %s", err.Error())
+		}
+	}
+	read, err := ReadAll(&buf)
+	if err != nil {
+		t.Fatalf("Could not read capture: %s", err.Error())
+	}
+	if !reflect.DeepEqual(records, read) {
+		t.Fatalf("Expected %+v, got %+v", records, read)
+	}
+}
+
+func TestReader_Errors(t *testing.T) {
+	if _, err := NewReader(strings.NewReader("not a capture")); err != ErrBadMagic {
+		t.Fatalf("Expected ErrBadMagic, got %v", err)
+	}
+	truncated := Magic + "\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x05\x1e"
+	if _, err := ReadAll(strings.NewReader(truncated)); err == nil {
+		t.Fatal("Expected an error for a truncated record")
+	}
+}
@@ -1,5 +1,10 @@
 package go_i2cp
 
+import (
+	"fmt"
+	"io"
+)
+
 const (
 	CERTIFICATE_NULL     uint8 = iota
 	CERTIFICATE_HASHCASH uint8 = iota
@@ -19,19 +24,20 @@ func NewCertificate(typ uint8) (cert Certificate) {
 }
 
 func NewCertificateFromMessage(stream *Stream) (cert Certificate, err error) {
-	cert.certType, err = stream.ReadByte()
-	cert.length, err = stream.ReadUint16()
-	if err != nil {
+	if cert.certType, err = stream.ReadByte(); err != nil {
 		return
 	}
-	if (cert.certType != CERTIFICATE_NULL) && (cert.length != 0) {
-		Fatal(CERTIFICATE|PROTOCOL, "Only null certificates are allowed to have zero length.")
+	if cert.length, err = stream.ReadUint16(); err != nil {
 		return
-	} else if cert.certType == CERTIFICATE_NULL {
+	}
+	if cert.certType == CERTIFICATE_NULL {
+		if cert.length != 0 {
+			err = &ProtocolError{Type: I2CP_MSG_ANY, Reason: fmt.Sprintf("null certificate with length %d", cert.length)}
+		}
 		return
 	}
 	cert.data = make([]byte, cert.length)
-	_, err = stream.Read(cert.data)
+	_, err = io.ReadFull(stream, cert.data)
 	return
 }
 
@@ -48,7 +54,7 @@ func (cert *Certificate) Copy() (newCert Certificate) {
 }
 
 func (cert *Certificate) WriteToMessage(stream *Stream) (err error) {
-	err = stream.WriteByte(cert.certType)
+	stream.WriteByte(cert.certType)
 	err = stream.WriteUint16(cert.length)
 	if cert.length > 0 {
 		_, err = stream.Write(cert.data)
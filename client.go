@@ -3,12 +3,19 @@ package go_i2cp
 import (
 	"bytes"
 	"compress/gzip"
-	"compress/zlib"
+	"context"
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
 	"os"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/wkoomson/go-i2cp/message"
 )
 
 const I2CP_CLIENT_VERSION = "0.9.33"
@@ -18,28 +25,31 @@ const I2CP_MESSAGE_SIZE = 0xffff
 const I2CP_MAX_SESSIONS = 0xffff
 const I2CP_MAX_SESSIONS_PER_CLIENT = 32
 
+// The I2CP_MSG_* message types are the ones of package message.
 const I2CP_MSG_ANY uint8 = 0
-const I2CP_MSG_BANDWIDTH_LIMITS uint8 = 23
-const I2CP_MSG_CREATE_LEASE_SET uint8 = 4
-const I2CP_MSG_CREATE_SESSION uint8 = 1
-const I2CP_MSG_DEST_LOOKUP uint8 = 34
-const I2CP_MSG_DEST_REPLY uint8 = 35
-const I2CP_MSG_DESTROY_SESSION uint8 = 3
-const I2CP_MSG_DISCONNECT uint8 = 30
-const I2CP_MSG_GET_BANDWIDTH_LIMITS uint8 = 8
-const I2CP_MSG_GET_DATE uint8 = 32
-const I2CP_MSG_HOST_LOOKUP uint8 = 38
-const I2CP_MSG_HOST_REPLY uint8 = 39
-const I2CP_MSG_MESSAGE_STATUS uint8 = 22
-const I2CP_MSG_PAYLOAD_MESSAGE uint8 = 31
-const I2CP_MSG_REQUEST_LEASESET uint8 = 21
-const I2CP_MSG_REQUEST_VARIABLE_LEASESET uint8 = 37
-const I2CP_MSG_SEND_MESSAGE uint8 = 5
-const I2CP_MSG_SESSION_STATUS uint8 = 20
-const I2CP_MSG_SET_DATE uint8 = 33
-
-/* Router capabilities */
-const ROUTER_CAN_HOST_LOOKUP uint32 = 1
+const I2CP_MSG_BANDWIDTH_LIMITS = message.TypeBandwidthLimits
+const I2CP_MSG_CREATE_LEASE_SET = message.TypeCreateLeaseSet
+const I2CP_MSG_CREATE_SESSION = message.TypeCreateSession
+const I2CP_MSG_DEST_LOOKUP = message.TypeDestLookup
+const I2CP_MSG_DEST_REPLY = message.TypeDestReply
+const I2CP_MSG_DESTROY_SESSION = message.TypeDestroySession
+const I2CP_MSG_DISCONNECT = message.TypeDisconnect
+const I2CP_MSG_GET_BANDWIDTH_LIMITS = message.TypeGetBandwidthLimits
+const I2CP_MSG_GET_DATE = message.TypeGetDate
+const I2CP_MSG_HOST_LOOKUP = message.TypeHostLookup
+const I2CP_MSG_HOST_REPLY = message.TypeHostReply
+const I2CP_MSG_MESSAGE_STATUS = message.TypeMessageStatus
+const I2CP_MSG_PAYLOAD_MESSAGE = message.TypeMessagePayload
+const I2CP_MSG_RECEIVE_MESSAGE_BEGIN = message.TypeReceiveMessageBegin
+const I2CP_MSG_RECEIVE_MESSAGE_END = message.TypeReceiveMessageEnd
+const I2CP_MSG_RECONFIGURE_SESSION = message.TypeReconfigureSession
+const I2CP_MSG_REPORT_ABUSE = message.TypeReportAbuse
+const I2CP_MSG_REQUEST_LEASESET = message.TypeRequestLeaseSet
+const I2CP_MSG_REQUEST_VARIABLE_LEASESET = message.TypeRequestVariableLeaseSet
+const I2CP_MSG_SEND_MESSAGE = message.TypeSendMessage
+const I2CP_MSG_SEND_MESSAGE_EXPIRES = message.TypeSendMessageExpires
+const I2CP_MSG_SESSION_STATUS = message.TypeSessionStatus
+const I2CP_MSG_SET_DATE = message.TypeSetDate
 
 type ClientProperty int
 
@@ -77,34 +87,45 @@ var defaultProperties = map[string]string{
 	"i2cp.reduceOnIdle":              "",
 	"i2cp.reduceQuantity":            "",
 	"i2cp.SSL":                       "",
+	"i2cp.SSL.caFile":                "",
+	"i2cp.SSL.clientCertificate":     "",
+	"i2cp.SSL.clientKey":             "",
+	"i2cp.SSL.fingerprint":           "",
 	"i2cp.tcp.host":                  "127.0.0.1",
 	"i2cp.tcp.port":                  "7654",
+	"i2cp.tcp.fallback":              "",
+	"i2cp.tcp.proxy":                 "",
 }
 
 type ClientCallBacks struct {
 	opaque       *interface{}
 	onDisconnect func(*Client, string, *interface{})
 	onLog        func(*Client, LoggerTags, string)
+	// OnError is called with errors that happen while dispatching messages
+	// from the router, e.g. a *ProtocolError or *UnknownSessionError.
+	OnError func(*Client, error)
+	// OnReconnect is called with the progress of automatic reconnects.
+	OnReconnect func(*Client, ReconnectEvent)
 }
 type LookupEntry struct {
 	address string
 	session *Session
-}
-type RouterInfo struct {
-	date         uint64
-	version      Version
-	capabilities uint32
+	// waiter receives the result of LookupContext calls instead of the
+	// session's onDestination callback
+	waiter chan *Destination
 }
 
+// Client is a connection to an I2P router. Its methods and the methods of
+// its sessions are safe for concurrent use by multiple goroutines.
 type Client struct {
 	logger          *LoggerCallbacks // TODO idk wat this is for
 	callbacks       *ClientCallBacks
 	properties      map[string]string
-	tcp             Tcp
-	outputStream    *Stream
-	messageStream   *Stream
+	tcp             *Tcp
+	transport       Transport
 	router          RouterInfo
-	outputQueue     []*Stream
+	outputQueue     sendQueue
+	queueConfig     QueueConfig
 	sessions        map[uint16]*Session
 	n_sessions      int
 	lookup          map[string]uint32
@@ -112,516 +133,1035 @@ type Client struct {
 	lock            sync.Mutex
 	connected       bool
 	currentSession  *Session // *opaque in the C lib
-	lookupRequestId uint32
+	lookupRequestId atomic.Uint32
+	reconnectPolicy *ReconnectPolicy
+	reconnecting    bool
+	closed          bool
+	outputReady     chan struct{}
+	sendLock        sync.Mutex
+	done            chan struct{}
+	ioErr           error
+	sessionWaiter   chan SessionStatus
+	createLock      chan struct{}
+	destroyWaiters  map[uint16]chan struct{}
+	// reconfigureWaiters receive the status answering a ReconfigureSession
+	reconfigureWaiters map[uint16]chan SessionStatus
+	flushLock          sync.Mutex
+	keepalivePolicy    *KeepalivePolicy
+	lastSeen           time.Time
+	rtt                time.Duration
+	pingTime           time.Time
+	missedPings        int
+	keepaliveErr       error
+	clockOffset        time.Duration
+	bandwidthLimits    *BandwidthLimits
+	bandwidthWaiters   []chan BandwidthLimits
+	rateLimiter        *rateLimiter
+	credentialProvider CredentialProvider
+	// auth are the credentials of the connection, authSent is set when
+	// they went out with the handshake's GetDate
+	auth     *Credentials
+	authSent bool
 }
 
 var defaultConfigFile = "/.i2cp.conf"
 
+// defaultLookupTimeout is the lookup timeout in milliseconds sent to the router.
+const defaultLookupTimeout = uint32(30000)
+
 // NewClient creates a new i2p client with the specified callbacks
 func NewClient(callbacks *ClientCallBacks) (c *Client) {
 	c = new(Client)
 	c.callbacks = callbacks
 	LogInit(nil, ERROR)
-	c.outputStream = NewStream(make([]byte, 0, I2CP_MESSAGE_SIZE))
-	c.messageStream = NewStream(make([]byte, 0, I2CP_MESSAGE_SIZE))
+	c.outputReady = make(chan struct{}, 1)
+	c.createLock = make(chan struct{}, 1)
+	c.tcp = &Tcp{}
 	c.setDefaultProperties()
 	c.lookup = make(map[string]uint32, 1000)
 	c.lookupReq = make(map[uint32]LookupEntry, 1000)
 	c.sessions = make(map[uint16]*Session)
-	c.outputQueue = make([]*Stream, 0)
+	c.destroyWaiters = make(map[uint16]chan struct{})
+	c.reconfigureWaiters = make(map[uint16]chan SessionStatus)
+	c.queueConfig = *DefaultQueueConfig()
 	c.tcp.Init()
+	c.transport = c.tcp
 	return
 }
 
 func (c *Client) setDefaultProperties() {
-	c.properties = defaultProperties
+	c.properties = make(map[string]string, len(defaultProperties))
+	for name, value := range defaultProperties {
+		c.properties[name] = value
+		c.SetProperty(name, value)
+	}
 	home := os.Getenv("HOME")
 	if len(home) == 0 {
 		return
 	}
 	config := home + defaultConfigFile
 	Debug(CLIENT, "Loading config file %s", config)
-	ParseConfig(config, c.SetProperty)
-}
-
-func (c *Client) sendMessage(typ uint8, stream *Stream, queue bool) (err error) {
-	send := NewStream(make([]byte, 0, stream.Len()+4+1))
-	err = send.WriteUint32(uint32(stream.Len()))
-	err = send.WriteByte(typ)
-	lenb := stream.Len()
-	_ = lenb
-	_, err = send.Write(stream.Bytes())
-	lenc := send.Len()
-	_ = lenc
+	if err := ParseConfig(config, c.SetProperty); err != nil {
+		Debug(CLIENT, "Not using config file %s: %s", config, err.Error())
+	}
+}
+
+func (c *Client) sendMessage(m message.Message, queue bool) (err error) {
+	var frame []byte
+	if frame, err = message.Encode(m); err != nil {
+		return
+	}
+	send := NewStream(frame)
 	if queue {
-		Debug(PROTOCOL, "Putting %d bytes message on the output queue.", send.Len())
-		c.lock.Lock()
-		c.outputQueue = append(c.outputQueue, send)
-		c.lock.Unlock()
+		err = c.queueMessage(context.Background(), m.Type(), send, false)
 	} else {
-		_, err = c.tcp.Send(send)
+		_, err = c.send(send)
 	}
 	return
 }
 
-func (c *Client) recvMessage(typ uint8, stream *Stream, dispatch bool) (err error) {
-	length := uint32(0)
-	msgType := uint8(0)
-	var i int
-	firstFive := NewStream(make([]byte, 5))
-	i, err = c.tcp.Receive(firstFive)
-	if i == 0 {
-		c.callbacks.onDisconnect(c, "Didn't receive anything", nil)
-	}
-	length, err = firstFive.ReadUint32()
-	msgType, err = firstFive.ReadByte()
-	if (typ == I2CP_MSG_SET_DATE) && (length > 0xffff) {
-		Fatal(PROTOCOL, "Unexpected response, check that your router SSL settings match the ~/.i2cp.conf configuration")
+// readMessage reads the next frame from the router, an error leaves the
+// connection unusable.
+func (c *Client) readMessage(expected uint8) (msgType uint8, stream *Stream, err error) {
+	var body []byte
+	msgType, body, err = message.ReadFrame(transportReader{c.transport}, I2CP_MESSAGE_SIZE)
+	if err != nil {
+		if errors.Is(err, message.ErrTooLarge) {
+			if expected == I2CP_MSG_SET_DATE {
+				Error(PROTOCOL, "Unexpected response, check that your router SSL settings match the ~/.i2cp.conf configuration")
+			}
+			// the rest of the stream can't be framed anymore
+			c.transport.Close()
+			err = &ProtocolError{Type: msgType, Reason: "message too large", Err: err}
+		}
+		return
 	}
-	if length > 0xffff && typ != I2CP_MSG_DISCONNECT {
-		Fatal(PROTOCOL, "unexpected message length, length > 0xffff")
+	stream = NewStream(body)
+	Debug(PROTOCOL, "Received message type %d with %d bytes", msgType, stream.Len())
+	c.seen(time.Now())
+	return
+}
+
+// recvMessage reads the next frame, which must be of type typ unless typ is
+// I2CP_MSG_ANY or the router disconnects, and dispatches it.
+func (c *Client) recvMessage(typ uint8, dispatch bool) (err error) {
+	var msgType uint8
+	var stream *Stream
+	if msgType, stream, err = c.readMessage(typ); err != nil {
+		return
 	}
-	if (typ != 0) && (msgType != typ) {
-		Error(PROTOCOL, "expected message type %d, received %d", typ, msgType)
+	if (typ != I2CP_MSG_ANY) && (msgType != typ) && (msgType != I2CP_MSG_DISCONNECT) {
+		return &ProtocolError{Type: msgType, Reason: fmt.Sprintf("expected message type %d", typ)}
 	}
-	// receive rest
-	i, err = c.tcp.Receive(stream)
-
 	if dispatch {
-		c.onMessage(msgType, stream)
+		err = c.onMessage(msgType, stream)
+		if _, ok := err.(*DisconnectError); ok {
+			c.transport.Close()
+		}
 	}
 	return
 }
 
-func (c *Client) onMessage(msgType uint8, stream *Stream) {
-	switch msgType {
-	case I2CP_MSG_SET_DATE:
-		c.onMsgSetDate(stream)
-	case I2CP_MSG_DISCONNECT:
-		c.onMsgDisconnect(stream)
-	case I2CP_MSG_PAYLOAD_MESSAGE:
-		c.onMsgPayload(stream)
-	case I2CP_MSG_MESSAGE_STATUS:
-		c.onMsgStatus(stream)
-	case I2CP_MSG_DEST_REPLY:
-		c.onMsgDestReply(stream)
-	case I2CP_MSG_BANDWIDTH_LIMITS:
-		c.onMsgBandwithLimit(stream)
-	case I2CP_MSG_SESSION_STATUS:
-		c.onMsgSessionStatus(stream)
-	case I2CP_MSG_REQUEST_VARIABLE_LEASESET:
-		c.onMsgReqVariableLease(stream)
-	case I2CP_MSG_HOST_REPLY:
-		c.onMsgHostReply(stream)
+func (c *Client) onMessage(msgType uint8, stream *Stream) (err error) {
+	var msg message.Message
+	if msg, err = message.Decode(msgType, stream.Bytes()); err != nil {
+		switch {
+		case errors.Is(err, message.ErrUnknownType):
+			msg = nil
+		case msgType == I2CP_MSG_DISCONNECT:
+			// older routers sent the reason without length prefix
+			msg = &message.Disconnect{Reason: string(stream.Bytes())}
+		default:
+			return &ProtocolError{Type: msgType, Reason: "malformed message", Err: err}
+		}
+	}
+	switch msg := msg.(type) {
+	case *message.SetDate:
+		err = c.onMsgSetDate(msg)
+	case *message.Disconnect:
+		err = c.onMsgDisconnect(msg)
+	case *message.MessagePayload:
+		err = c.onMsgPayload(msg)
+	case *message.MessageStatus:
+		err = c.onMsgStatus(msg)
+	case *message.DestReply:
+		err = c.onMsgDestReply(msg)
+	case *message.BandwidthLimits:
+		err = c.onMsgBandwithLimit(msg)
+	case *message.SessionStatus:
+		err = c.onMsgSessionStatus(msg)
+	case *message.RequestVariableLeaseSet:
+		err = c.onMsgReqVariableLease(msg)
+	case *message.HostReply:
+		err = c.onMsgHostReply(msg)
 	default:
-		Info(TAG, "%s", "recieved unhandled i2cp message.")
+		Info(TAG, "recieved unhandled i2cp message type %d.", msgType)
 	}
+	return
 }
-func (c *Client) onMsgSetDate(stream *Stream) {
+func (c *Client) onMsgSetDate(msg *message.SetDate) (err error) {
 	Debug(TAG|PROTOCOL, "Received SetDate message.")
-	var err error
-	c.router.date, err = stream.ReadUint64()
-	var verLength uint8
-	verLength, err = stream.ReadByte()
-	version := make([]byte, verLength)
-	_, err = stream.Read(version)
-	c.router.version = parseVersion(string(version))
-	Debug(TAG|PROTOCOL, "Router version %s, date %d", string(version), c.router.date)
-	if err != nil {
-		Error(TAG|PROTOCOL, "Could not read SetDate correctly data")
-	}
-	if c.router.version.compare(Version{major: 0, minor: 9, micro: 10, qualifier: 0}) >= 0 {
-		c.router.capabilities |= ROUTER_CAN_HOST_LOOKUP
-	}
+	now := time.Now()
+	rtt := c.pong(now)
+	router := newRouterInfo(msg.Date, msg.Version)
+	c.updateClockOffset(router.Date, now, rtt)
+	c.lock.Lock()
+	c.router = router
+	c.lock.Unlock()
+	Debug(TAG|PROTOCOL, "Router version %s, date %d", msg.Version, msg.Date)
+	return
 }
-func (c *Client) onMsgDisconnect(stream *Stream) {
-	var err error
-	Debug(TAG|PROTOCOL, "Received Disconnect message")
-	//size, err = stream.ReadByte()
-	strbuf := make([]byte, stream.Len())
-	lens := stream.Len()
-	_ = lens
-	_, err = stream.Read(strbuf)
-
-	Debug(TAG|PROTOCOL, "Received Disconnect message with reason %s", string(strbuf))
-	if err != nil {
-		Error(TAG|PROTOCOL, "Could not read msgDisconnect correctly data")
-	}
-	if c.callbacks != nil && c.callbacks.onDisconnect != nil {
-		c.callbacks.onDisconnect(c, string(strbuf), nil)
-	}
+func (c *Client) onMsgDisconnect(msg *message.Disconnect) (err error) {
+	Debug(TAG|PROTOCOL, "Received Disconnect message with reason %s", msg.Reason)
+	return &DisconnectError{Reason: msg.Reason}
 }
-func (c *Client) onMsgPayload(stream *Stream) {
+func (c *Client) onMsgPayload(msg *message.MessagePayload) (err error) {
 	var gzipHeader = [3]byte{0x1f, 0x8b, 0x08}
 	var testHeader [3]byte
 	var protocol uint8
-	var sessionId, srcPort, destPort uint16
-	var messageId, payloadSize uint32
-	var err error
-	var ret int
+	var srcPort, destPort uint16
 	Debug(TAG|PROTOCOL, "Received PayloadMessage message")
-	sessionId, err = stream.ReadUint16()
-	messageId, err = stream.ReadUint32()
-	_ = messageId // currently unused
-	session, ok := c.sessions[sessionId]
+	c.lock.Lock()
+	session, ok := c.sessions[msg.SessionId]
+	c.lock.Unlock()
 	if !ok {
-		Fatal(TAG|FATAL, "Session id %d does not match any of our currently initiated sessions by %p", sessionId, c)
+		return &UnknownSessionError{SessionId: msg.SessionId, Type: I2CP_MSG_PAYLOAD_MESSAGE}
+	}
+	if len(msg.Payload) < 10 {
+		return &ProtocolError{Type: I2CP_MSG_PAYLOAD_MESSAGE, Reason: fmt.Sprintf("payload of %d bytes is too short", len(msg.Payload))}
 	}
-	payloadSize, err = stream.ReadUint32()
-	_ = payloadSize // currently unused
-	// validate gzip header
-	var msgStream = bytes.NewBuffer(stream.Bytes())
-	_, err = stream.Read(testHeader[:])
+	// the gzip header carries ports and protocol in its mtime and os fields
+	header := msg.Payload[:10]
+	copy(testHeader[:], header)
 	if testHeader != gzipHeader {
 		Warning(TAG, "Payload validation failed, skipping payload")
-		return
+		return &ProtocolError{Type: I2CP_MSG_PAYLOAD_MESSAGE, Reason: "payload is not gzip compressed"}
 	}
-	var payload = bytes.NewBuffer(make([]byte, 0xffff))
-	var decompress io.ReadCloser
-	decompress, err = zlib.NewReader(msgStream)
-	io.Copy(payload, decompress)
+	srcPort = binary.LittleEndian.Uint16(header[4:6])
+	destPort = binary.LittleEndian.Uint16(header[6:8])
+	protocol = header[9]
+	var decompress *gzip.Reader
+	if decompress, err = gzip.NewReader(bytes.NewReader(msg.Payload)); err != nil {
+		return &ProtocolError{Type: I2CP_MSG_PAYLOAD_MESSAGE, Reason: "invalid gzip payload", Err: err}
+	}
+	payload := NewStream(make([]byte, 0, len(msg.Payload)))
+	_, err = io.Copy(payload, decompress)
 	decompress.Close()
-	if payload.Len() > 0 {
-		// finish reading header
-		// skip gzip flags
-		_, err = stream.ReadByte()
-		srcPort, err = stream.ReadUint16()
-		destPort, err = stream.ReadUint16()
-		_, err = stream.ReadByte()
-		protocol, err = stream.ReadByte()
-		session.dispatchMessage(protocol, srcPort, destPort, &Stream{payload})
-	}
-	_ = err // currently unused
-	_ = ret // currently unused
-}
-func (c *Client) onMsgStatus(stream *Stream) {
-	var status uint8
-	var sessionId uint16
-	var messageId, size, nonce uint32
-	var err error
-	Debug(TAG|PROTOCOL, "Received MessageStatus message")
-	sessionId, err = stream.ReadUint16()
-	messageId, err = stream.ReadUint32()
-	status, err = stream.ReadByte()
-	size, err = stream.ReadUint32()
-	nonce, err = stream.ReadUint32()
-	_ = err // currently unused
-	Debug(TAG|PROTOCOL, "Message status; session id %d, message id %d, status %d, size %d, nonce %d", sessionId, messageId, status, size, nonce)
-}
-func (c *Client) onMsgDestReply(stream *Stream) {
+	if err != nil {
+		return &ProtocolError{Type: I2CP_MSG_PAYLOAD_MESSAGE, Reason: "invalid gzip payload", Err: err}
+	}
+	session.dispatchMessage(protocol, srcPort, destPort, payload)
+	return nil
+}
+func (c *Client) onMsgStatus(msg *message.MessageStatus) (err error) {
+	Debug(TAG|PROTOCOL, "Message status; session id %d, message id %d, status %d, size %d, nonce %d", msg.SessionId, msg.MessageId, msg.Status, msg.Size, msg.Nonce)
+	if SessionMessageStatus(msg.Status) == I2CP_MSG_STATUS_OVERFLOW_FAILURE {
+		Warning(TAG, "Router dropped message %d of session %d, its queue overflowed. Consider a rate limit.", msg.MessageId, msg.SessionId)
+	}
+	return
+}
+func (c *Client) onMsgDestReply(msg *message.DestReply) (err error) {
 	var b32 string
 	var destination *Destination
 	var lup LookupEntry
-	var err error
 	var requestId uint32
 	Debug(TAG|PROTOCOL, "Received DestReply message.")
-	if stream.Len() != 32 {
-		destination, err = NewDestinationFromMessage(stream)
+	if msg.Destination != nil {
+		destination, err = NewDestinationFromMessage(NewStream(msg.Destination))
 		if err != nil {
-			Fatal(TAG|FATAL, "Failed to construct destination from stream")
+			return &ProtocolError{Type: I2CP_MSG_DEST_REPLY, Reason: "invalid destination", Err: err}
 		}
 		b32 = destination.b32
 	} else {
-		bits := GetCryptoInstance().EncodeStream(CODEC_BASE32, stream)
+		bits := GetCryptoInstance().EncodeStream(CODEC_BASE32, NewStream(msg.Hash[:]))
 		b32 = string(bits.Bytes()) + ".b32.i2p"
 		Debug(TAG, "Could not resolve destination")
 	}
+	c.lock.Lock()
 	requestId = c.lookup[b32]
 	delete(c.lookup, b32)
 	lup = c.lookupReq[requestId]
 	delete(c.lookupReq, requestId)
+	c.lock.Unlock()
 	if lup == (LookupEntry{}) {
 		Warning(TAG, "No sesssion for destination lookup of address '%s'", b32)
 	} else {
-		lup.session.dispatchDestination(requestId, b32, destination)
+		lup.dispatch(requestId, b32, destination)
 	}
+	return
 }
-func (c *Client) onMsgBandwithLimit(stream *Stream) {
-	Debug(TAG|PROTOCOL, "Received BandwidthLimits message.")
-}
-func (c *Client) onMsgSessionStatus(stream *Stream) {
+func (c *Client) onMsgSessionStatus(msg *message.SessionStatus) (err error) {
 	var sess *Session
-	var sessionID uint16
-	var sessionStatus uint8
-	var err error
+	var stateErr error
+	sessionID := msg.SessionId
 	Debug(TAG|PROTOCOL, "Received SessionStatus message.")
-	sessionID, err = stream.ReadUint16()
-	sessionStatus, err = stream.ReadByte()
-	_ = err // currently unused
-	if SessionStatus(sessionStatus) == I2CP_SESSION_STATUS_CREATED {
-		if c.currentSession == nil {
-			Error(TAG, "Received session status created without waiting for it %p", c)
-			return
-		}
-		c.currentSession.id = sessionID
-		c.sessions[sessionID] = c.currentSession
+	status := SessionStatus(msg.Status)
+	c.lock.Lock()
+	pending := c.currentSession
+	if pending != nil && (status == I2CP_SESSION_STATUS_CREATED || c.sessions[sessionID] == nil) {
+		// answer to the session we are creating, anything but created means
+		// the router refused it
 		c.currentSession = nil
+		if status == I2CP_SESSION_STATUS_CREATED {
+			pending.id = sessionID
+			c.sessions[sessionID] = pending
+			if pending.primary != nil {
+				pending.primary.addSubsession(pending)
+			}
+			stateErr = pending.setStateLocked(SESSION_STATE_CREATED)
+		} else {
+			stateErr = pending.setStateLocked(SESSION_STATE_INVALID)
+		}
+	} else {
+		pending = nil
 	}
 	sess = c.sessions[sessionID]
+	c.lock.Unlock()
+	if pending != nil {
+		pending.dispatchStatus(status)
+		c.resolveSessionWaiter(status)
+		if stateErr != nil {
+			return &ProtocolError{Type: I2CP_MSG_SESSION_STATUS, Reason: "unexpected session status " + status.String(), Err: stateErr}
+		}
+		return
+	}
+	if status == I2CP_SESSION_STATUS_CREATED {
+		// most likely a CreateSessionContext call that was cancelled, don't
+		// leave the session behind on the router
+		Warning(TAG, "Received session status created without waiting for it, destroying session %d", sessionID)
+		return c.msgDestroySession(&Session{id: sessionID}, true)
+	}
 	if sess == nil {
-		Fatal(TAG|FATAL, "Session with id %d doesn't exists in client instance %p.", sessionID, c)
-	} else {
-		sess.dispatchStatus(SessionStatus(sessionStatus))
+		return &UnknownSessionError{SessionId: sessionID, Type: I2CP_MSG_SESSION_STATUS}
+	}
+	c.lock.Lock()
+	switch _, reconfiguring := c.reconfigureWaiters[sessionID]; {
+	case status == I2CP_SESSION_STATUS_UPDATED:
+		stateErr = sess.setStateLocked(SESSION_STATE_UPDATED)
+	case status == I2CP_SESSION_STATUS_INVALID && !reconfiguring:
+		// a refused reconfiguration leaves the session as it was
+		stateErr = sess.setStateLocked(SESSION_STATE_INVALID)
 	}
+	c.lock.Unlock()
+	var subsessions []*Session
+	if status == I2CP_SESSION_STATUS_DESTROYED {
+		subsessions = c.forgetSession(sess)
+	}
+	c.resolveReconfigureWaiter(sessionID, status)
+	if stateErr != nil {
+		return &ProtocolError{Type: I2CP_MSG_SESSION_STATUS, Reason: "unexpected session status " + status.String(), Err: stateErr}
+	}
+	for _, sub := range subsessions {
+		sub.dispatchStatus(I2CP_SESSION_STATUS_DESTROYED)
+	}
+	sess.dispatchStatus(status)
+	return
 }
-func (c *Client) onMsgReqVariableLease(stream *Stream) {
-	var sessionId uint16
-	var tunnels uint8
-	var sess *Session
-	var leases []*Lease
-	var err error
+
+// forgetSession removes a destroyed session and wakes up DestroySession. The
+// router destroys the subsessions with their primary, the ones it didn't
+// report destroyed yet are forgotten too and returned.
+func (c *Client) forgetSession(sess *Session) (subsessions []*Session) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.forgetSessionLocked(sess)
+	if sess.primary != nil {
+		sess.primary.removeSubsession(sess)
+	}
+	subsessions, sess.subsessions = sess.subsessions, nil
+	for _, sub := range subsessions {
+		c.forgetSessionLocked(sub)
+	}
+	return
+}
+
+func (c *Client) forgetSessionLocked(sess *Session) {
+	if c.sessions[sess.id] == sess {
+		delete(c.sessions, sess.id)
+	}
+	sess.setStateLocked(SESSION_STATE_DESTROYED)
+	if waiter, ok := c.destroyWaiters[sess.id]; ok {
+		delete(c.destroyWaiters, sess.id)
+		close(waiter)
+	}
+}
+
+// sessionId returns the id the router assigned to sess, it changes when the
+// session is restored after a reconnect.
+func (c *Client) sessionId(sess *Session) uint16 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return sess.id
+}
+
+// resolveSessionWaiter wakes up CreateSession once the router answered.
+func (c *Client) resolveSessionWaiter(status SessionStatus) {
+	c.lock.Lock()
+	waiter := c.sessionWaiter
+	c.sessionWaiter = nil
+	c.lock.Unlock()
+	if waiter != nil {
+		waiter <- status
+	}
+}
+func (c *Client) onMsgReqVariableLease(msg *message.RequestVariableLeaseSet) (err error) {
 	Debug(TAG|PROTOCOL, "Received RequestVariableLeaseSet message.")
-	sessionId, err = stream.ReadUint16()
-	tunnels, err = stream.ReadByte()
-	sess = c.sessions[sessionId]
+	c.lock.Lock()
+	sess := c.sessions[msg.SessionId]
+	c.lock.Unlock()
 	if sess == nil {
-		Fatal(TAG|FATAL, "Session with id %d doesn't exist in client instance %p.", sessionId, c)
+		return &UnknownSessionError{SessionId: msg.SessionId, Type: I2CP_MSG_REQUEST_VARIABLE_LEASESET}
 	}
-	leases = make([]*Lease, tunnels)
-	for i := uint8(0); i < tunnels; i++ {
-		leases[i], err = NewLeaseFromStream(stream)
+	leases := make([]*Lease, len(msg.Leases))
+	for i, lease := range msg.Leases {
+		leases[i] = &Lease{tunnelGateway: lease.Gateway, tunnelId: lease.TunnelId, endDate: lease.EndDate}
 	}
-	c.msgCreateLeaseSet(sess, tunnels, leases, true)
-	_ = err // currently unused
+	return c.msgCreateLeaseSet(sess, uint8(len(leases)), leases, true)
 }
-func (c *Client) onMsgHostReply(stream *Stream) {
-	var result uint8
-	var sessionId uint16
-	var requestId uint32
-	var sess *Session
+func (c *Client) onMsgHostReply(msg *message.HostReply) (err error) {
 	var dest *Destination
 	var lup LookupEntry
-	var err error
 	Debug(TAG|PROTOCOL, "Received HostReply message.")
-	sessionId, err = stream.ReadUint16()
-	requestId, err = stream.ReadUint32()
-	result, err = stream.ReadByte()
-	if result == 0 {
-		dest, err = NewDestinationFromMessage(stream)
+	if msg.Result == 0 {
+		dest, err = NewDestinationFromMessage(NewStream(msg.Destination))
 		if err != nil {
-			Fatal(TAG|FATAL, "Failed to construct destination from stream.")
+			return &ProtocolError{Type: I2CP_MSG_HOST_REPLY, Reason: "invalid destination", Err: err}
 		}
 	}
-	sess = c.sessions[sessionId]
-	if sess == nil {
-		Fatal(TAG|FATAL, "Session with id %d doesn't exist in client instance %p.", sessionId, c)
+	c.lock.Lock()
+	_, known := c.sessions[msg.SessionId]
+	lup = c.lookupReq[msg.RequestId]
+	delete(c.lookupReq, msg.RequestId)
+	c.lock.Unlock()
+	if lup == (LookupEntry{}) {
+		Warning(TAG, "No pending lookup with request id %d", msg.RequestId)
+		return
 	}
-	lup = c.lookupReq[requestId]
-	delete(c.lookupReq, requestId)
-	sess.dispatchDestination(requestId, lup.address, dest)
-	_ = err // currently unused
+	if !known {
+		// the session went away while the lookup was pending
+		lup.dispatch(msg.RequestId, lup.address, nil)
+		return &UnknownSessionError{SessionId: msg.SessionId, Type: I2CP_MSG_HOST_REPLY}
+	}
+	lup.dispatch(msg.RequestId, lup.address, dest)
+	return
+}
+
+// dispatch hands a lookup result to the waiting LookupContext call or the
+// session callback.
+func (lup LookupEntry) dispatch(requestId uint32, address string, dest *Destination) {
+	if lup.waiter != nil {
+		lup.waiter <- dest
+		return
+	}
+	lup.session.dispatchDestination(requestId, address, dest)
 }
 
-func (c *Client) msgCreateLeaseSet(session *Session, tunnels uint8, leases []*Lease, queue bool) {
-	var err error
+func (c *Client) msgCreateLeaseSet(session *Session, tunnels uint8, leases []*Lease, queue bool) (err error) {
 	var nullbytes [256]byte
 	var leaseSet *Stream
 	var config *SessionConfig
 	var dest *Destination
 	var sgk *SignatureKeyPair
 	Debug(TAG|PROTOCOL, "Sending CreateLeaseSetMessage")
-	leaseSet = NewStream(make([]byte, 4096))
+	leaseSet = NewStream(make([]byte, 0, 4096))
 	config = session.config
 	dest = config.destination
 	sgk = &dest.sgk
-	// memset 0 nullbytes
-	for i := 0; i < len(nullbytes); i++ {
-		nullbytes[i] = 0
-	}
-	// construct the message
-	c.messageStream.WriteUint16(session.id)
-	c.messageStream.Write(nullbytes[:20])
-	c.messageStream.Write(nullbytes[:256])
 	//Build leaseset stream and sign it
-	dest.WriteToMessage(leaseSet)
+	if err = dest.WriteToMessage(leaseSet); err != nil {
+		return
+	}
 	leaseSet.Write(nullbytes[:256])
-	GetCryptoInstance().WritePublicSignatureToStream(sgk, leaseSet)
+	if err = GetCryptoInstance().WritePublicSignatureToStream(sgk, leaseSet); err != nil {
+		return
+	}
 	leaseSet.WriteByte(tunnels)
 	for i := uint8(0); i < tunnels; i++ {
 		leases[i].WriteToMessage(leaseSet)
 	}
-	GetCryptoInstance().SignStream(sgk, leaseSet)
-	c.messageStream.Write(leaseSet.Bytes())
-	if err = c.sendMessage(I2CP_MSG_CREATE_LEASE_SET, c.messageStream, queue); err != nil {
+	if err = GetCryptoInstance().SignStream(sgk, leaseSet); err != nil {
+		return
+	}
+	msg := &message.CreateLeaseSet{
+		SessionId:         c.sessionId(session),
+		SigningPrivateKey: nullbytes[:20],
+		PrivateKey:        nullbytes[:256],
+		LeaseSet:          leaseSet.Bytes(),
+	}
+	if err = c.sendMessage(msg, queue); err != nil {
 		Error(TAG, "Error while sending CreateLeaseSet")
+		return
 	}
+	// messages queued from now on are sent after the lease set
+	if stateErr := c.setSessionState(session, SESSION_STATE_LEASESET_PUBLISHED); stateErr != nil {
+		Debug(TAG, "Lease set sent for a session that went away: %s", stateErr.Error())
+	}
+	return
 }
-func (c *Client) msgGetDate(queue bool) {
-	var err error
+
+// msgGetDate sends a GetDate, with auth in the handshake and without for
+// heartbeats.
+func (c *Client) msgGetDate(auth *Credentials, queue bool) (err error) {
 	Debug(TAG|PROTOCOL, "Sending GetDateMessage")
-	c.messageStream.Reset()
-	c.messageStream.WriteLenPrefixedString(I2CP_CLIENT_VERSION)
-	if len(c.properties["i2cp.username"]) > 0 {
-		authInfo := map[string]string{
-			"i2cp.username": c.properties["i2cp.username"],
-			"i2cp.password": c.properties["i2cp.password"],
+	msg := &message.GetDate{Version: I2CP_CLIENT_VERSION}
+	if auth != nil {
+		msg.Options = map[string]string{
+			"i2cp.username": auth.Username,
+			"i2cp.password": auth.Password,
 		}
-		c.messageStream.WriteMapping(authInfo)
 	}
-	if err = c.sendMessage(I2CP_MSG_GET_DATE, c.messageStream, queue); err != nil {
+	if err = c.sendMessage(msg, queue); err != nil {
 		Error(TAG, "Error while sending GetDateMessage")
 	}
+	return
 }
-func (c *Client) msgCreateSession(config *SessionConfig, queue bool) {
-	var err error
+func (c *Client) msgCreateSession(config *SessionConfig, queue bool) (err error) {
 	Debug(TAG|PROTOCOL, "Sending CreateSessionMessage")
-	c.messageStream.Reset()
-	config.writeToMessage(c.messageStream)
-	if err = c.sendMessage(I2CP_MSG_CREATE_SESSION, c.messageStream, queue); err != nil {
+	msg := &message.CreateSession{}
+	if msg.SessionConfig, err = c.snapshotConfig(config).toMessage(c.Now(), c.sessionAuth()); err != nil {
+		return
+	}
+	if err = c.sendMessage(msg, queue); err != nil {
 		Error(TAG, "Error while sending CreateSessionMessage.")
 	}
+	return
 }
-func (c *Client) msgDestLookup(hash []byte, queue bool) {
+
+// snapshotConfig copies config under the client lock, Reconfigure changes
+// the properties of a session concurrently.
+func (c *Client) snapshotConfig(config *SessionConfig) *SessionConfig {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	snapshot := *config
+	return &snapshot
+}
+func (c *Client) msgDestLookup(hash []byte, queue bool) (err error) {
 	Debug(TAG|PROTOCOL, "Sending DestLookupMessage.")
-	c.messageStream.Reset()
-	c.messageStream.Write(hash)
-	if err := c.sendMessage(I2CP_MSG_DEST_LOOKUP, c.messageStream, queue); err != nil {
+	msg := &message.DestLookup{}
+	copy(msg.Hash[:], hash)
+	if err = c.sendMessage(msg, queue); err != nil {
 		Error(TAG, "Error while sending DestLookupMessage.")
 	}
+	return
 }
-func (c *Client) msgHostLookup(sess *Session, requestId, timeout uint32, typ uint8, data []byte, queue bool) {
-	var sessionId uint16
+func (c *Client) msgHostLookup(sess *Session, requestId, timeout uint32, typ uint8, data []byte, queue bool) (err error) {
 	Debug(TAG|PROTOCOL, "Sending HostLookupMessage.")
-	c.messageStream.Reset()
-	sessionId = sess.id
-	c.messageStream.WriteUint16(sessionId)
-	c.messageStream.WriteUint32(requestId)
-	c.messageStream.WriteUint32(timeout)
-	c.messageStream.WriteByte(typ)
+	msg := &message.HostLookup{
+		SessionId:  c.sessionId(sess),
+		RequestId:  requestId,
+		Timeout:    timeout,
+		LookupType: typ,
+	}
 	if typ == HOST_LOOKUP_TYPE_HASH {
-		c.messageStream.Write(data)
+		copy(msg.Hash[:], data)
+	} else {
+		msg.Host = string(data)
 	}
-	if err := c.sendMessage(I2CP_MSG_HOST_LOOKUP, c.messageStream, queue); err != nil {
+	if err = c.sendMessage(msg, queue); err != nil {
 		Error(TAG, "Error while sending HostLookupMessage")
 	}
+	return
 }
-func (c *Client) msgGetBandwidthLimits(queue bool) {
+func (c *Client) msgReconfigureSession(sess *Session, queue bool) (err error) {
+	Debug(TAG|PROTOCOL, "Sending ReconfigureSessionMessage")
+	msg := &message.ReconfigureSession{SessionId: c.sessionId(sess)}
+	if msg.SessionConfig, err = c.snapshotConfig(sess.config).toMessage(c.Now(), c.sessionAuth()); err != nil {
+		return
+	}
+	if err = c.sendMessage(msg, queue); err != nil {
+		Error(TAG, "Error while sending ReconfigureSessionMessage")
+	}
+	return
+}
+func (c *Client) msgGetBandwidthLimits(queue bool) (err error) {
 	Debug(TAG|PROTOCOL, "Sending GetBandwidthLimitsMessage.")
-	c.messageStream.Reset()
-	if err := c.sendMessage(I2CP_MSG_GET_BANDWIDTH_LIMITS, c.messageStream, queue); err != nil {
+	if err = c.sendMessage(&message.GetBandwidthLimits{}, queue); err != nil {
 		Error(TAG, "Error while sending GetBandwidthLimitsMessage")
 	}
+	return
 }
-func (c *Client) msgDestroySession(sess *Session, queue bool) {
+func (c *Client) msgDestroySession(sess *Session, queue bool) (err error) {
 	Debug(TAG|PROTOCOL, "Sending DestroySessionMessage")
-	c.messageStream.Reset()
-	c.messageStream.WriteUint16(sess.id)
-	if err := c.sendMessage(I2CP_MSG_DESTROY_SESSION, c.messageStream, queue); err != nil {
+	if err = c.sendMessage(&message.DestroySession{SessionId: c.sessionId(sess)}, queue); err != nil {
 		Error(TAG, "Error while sending DestroySessionMessage")
 	}
+	return
+}
+func (c *Client) msgDisconnect(reason string, queue bool) (err error) {
+	Debug(TAG|PROTOCOL, "Sending DisconnectMessage")
+	if err = c.sendMessage(&message.Disconnect{Reason: reason}, queue); err != nil {
+		Error(TAG, "Error while sending DisconnectMessage")
+	}
+	return
 }
-func (c *Client) msgSendMessage(sess *Session, dest *Destination, protocol uint8, srcPort, destPort uint16, payload *Stream, nonce uint32, queue bool) {
+
+// msgSendMessage queues a SendMessage, waiting for room in the output queue
+// until ctx is done if block is set.
+func (c *Client) msgSendMessage(ctx context.Context, sess *Session, dest *Destination, protocol uint8, srcPort, destPort uint16, payload *Stream, nonce uint32, block bool) (err error) {
 	Debug(TAG|PROTOCOL, "Sending SendMessageMessage")
-	out := bytes.NewBuffer(make([]byte, 0xffff))
-	c.messageStream.Reset()
-	c.messageStream.WriteUint16(sess.id)
-	dest.WriteToMessage(c.messageStream)
+	c.lock.Lock()
+	state, id := sess.state, sess.id
+	c.lock.Unlock()
+	if !state.usable() {
+		return &SessionStateError{SessionId: id, State: state}
+	}
+	out := bytes.NewBuffer(make([]byte, 0, payload.Len()+64))
 	compress := gzip.NewWriter(out)
-	compress.Write(payload.Bytes())
-	compress.Close()
+	if _, err = compress.Write(payload.Bytes()); err != nil {
+		return
+	}
+	if err = compress.Close(); err != nil {
+		return
+	}
 	header := out.Bytes()[:10]
 	binary.LittleEndian.PutUint16(header[4:6], srcPort)
 	binary.LittleEndian.PutUint16(header[6:8], destPort)
 	header[9] = protocol
-	c.messageStream.WriteUint32(uint32(out.Len()))
-	c.messageStream.Write(out.Bytes())
-	c.messageStream.WriteUint32(nonce)
-	if err := c.sendMessage(I2CP_MSG_SEND_MESSAGE, c.messageStream, queue); err != nil {
-		Error(TAG, "Error while sending SendMessageMessage")
+	destination := NewStream(make([]byte, 0, 512))
+	if err = dest.WriteToMessage(destination); err != nil {
+		return
+	}
+	msg := &message.SendMessage{
+		SessionId:   c.sessionId(sess),
+		Destination: destination.Bytes(),
+		Payload:     out.Bytes(),
+		Nonce:       nonce,
+	}
+	var encoded []byte
+	if encoded, err = message.Encode(msg); err != nil {
+		return
+	}
+	frame := NewStream(encoded)
+	unshape, err := c.shape(ctx, sess, frame.Len(), block)
+	if err != nil {
+		return
+	}
+	if err = c.queueMessage(ctx, I2CP_MSG_SEND_MESSAGE, frame, block); err != nil {
+		// the message isn't sent, don't charge the rate limits for it
+		unshape()
+		Error(TAG, "Error while sending SendMessageMessage: %s", err.Error())
 	}
+	return
+}
+func (c *Client) Connect() error {
+	return c.ConnectContext(context.Background())
 }
-func (c *Client) Connect() {
-	Info(0, "Client connecting to i2cp at %s:%s", c.properties["i2cp.tcp.host"], c.properties["i2cp.tcp.host"])
-	err := c.tcp.Connect()
+
+// ConnectContext connects to the router and performs the I2CP handshake. The
+// handshake is aborted and the transport closed when ctx is done first.
+func (c *Client) ConnectContext(ctx context.Context) (err error) {
+	c.lock.Lock()
+	Info(0, "Client connecting to i2cp at %s:%s", c.properties["i2cp.tcp.host"], c.properties["i2cp.tcp.port"])
+	c.closed = false
+	c.lock.Unlock()
+	if err = connectTransport(ctx, c.transport); err != nil {
+		return
+	}
+	if endpoint := c.RouterEndpoint(); endpoint != "" {
+		Info(TAG, "Client connected to i2cp at %s", endpoint)
+	}
+	// closing the transport unblocks the handshake
+	stop := context.AfterFunc(ctx, func() { c.transport.Close() })
+	err = c.handshake(ctx)
+	if !stop() {
+		return ctx.Err()
+	}
 	if err != nil {
-		panic(err)
+		Error(TAG, "Handshake with router failed: %s", err.Error())
+		c.transport.Close()
+		return
+	}
+	c.startIO()
+	return
+}
+
+// handshake sends the protocol byte and exchanges GetDate/SetDate on a
+// freshly connected transport.
+func (c *Client) handshake(ctx context.Context) (err error) {
+	var auth *Credentials
+	if auth, err = c.credentials(ctx); err != nil {
+		return
 	}
-	c.outputStream.Reset()
-	c.outputStream.WriteByte(I2CP_PROTOCOL_INIT)
-	_, err = c.tcp.Send(c.outputStream)
 	Debug(PROTOCOL, "Sending protocol byte message")
-	c.msgGetDate(false)
-	c.recvMessage(I2CP_MSG_SET_DATE, c.messageStream, true)
+	if _, err = c.send(NewStream([]byte{I2CP_PROTOCOL_INIT})); err != nil {
+		return
+	}
+	c.pingSent(time.Now())
+	if err = c.msgGetDate(auth, false); err != nil {
+		return
+	}
+	if err = c.recvMessage(I2CP_MSG_SET_DATE, true); err != nil {
+		return authError(err, auth)
+	}
+	return
+}
+
+func (c *Client) CreateSession(sess *Session) error {
+	return c.CreateSessionContext(context.Background(), sess)
 }
 
-func (c *Client) CreateSession(sess *Session) {
-	if c.n_sessions == I2CP_MAX_SESSIONS_PER_CLIENT {
+// CreateSessionContext creates sess on the router and waits for its status.
+// If ctx is done first the pending creation is abandoned, a late answer from
+// the router destroys the session again.
+func (c *Client) CreateSessionContext(ctx context.Context, sess *Session) error {
+	if !c.IsConnected() {
+		return ErrNotConnected
+	}
+	c.lock.Lock()
+	full := len(c.sessions) >= I2CP_MAX_SESSIONS_PER_CLIENT
+	c.lock.Unlock()
+	if full {
 		Warning(TAG, "Maximum number of session per client connection reached.")
-		return
+		return ErrTooManySessions
 	}
+	// Reconfigure changes the config under the lock
+	c.lock.Lock()
 	sess.config.SetProperty(SESSION_CONFIG_PROP_I2CP_FAST_RECEIVE, "true")
 	sess.config.SetProperty(SESSION_CONFIG_PROP_I2CP_MESSAGE_RELIABILITY, "none")
-	c.msgCreateSession(sess.config, false)
+	c.lock.Unlock()
+	// the reader goroutine answers through sessionWaiter, one session is
+	// created at a time
+	select {
+	case c.createLock <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-c.createLock }()
+	waiter := make(chan SessionStatus, 1)
+	c.lock.Lock()
+	sess.setStateLocked(SESSION_STATE_PENDING)
 	c.currentSession = sess
-	c.recvMessage(I2CP_MSG_ANY, c.messageStream, true)
+	c.sessionWaiter = waiter
+	done := c.done
+	c.lock.Unlock()
+	c.sessionConfigCredentials(sess.config)
+	if err := c.msgCreateSession(sess.config, false); err != nil {
+		c.abandonSession(sess)
+		return err
+	}
+	select {
+	case status, ok := <-waiter:
+		if !ok {
+			// the connection was lost, dropPending gave up the creation
+			return ErrRouterDisconnected
+		}
+		if status != I2CP_SESSION_STATUS_CREATED {
+			return ErrSessionRefused
+		}
+		return nil
+	case <-done:
+		c.abandonSession(sess)
+		return ErrNotConnected
+	case <-ctx.Done():
+		c.abandonSession(sess)
+		return ctx.Err()
+	}
 }
 
-func (c *Client) ProcessIO() error {
+// abandonSession forgets a session creation nobody waits for anymore.
+func (c *Client) abandonSession(sess *Session) {
 	c.lock.Lock()
-	for _, stream := range c.outputQueue {
-		Debug(TAG|PROTOCOL, "Sending %d bytes message", stream.Len())
-		ret, err := c.tcp.Send(stream)
-		if ret < 0 {
-			c.lock.Unlock()
+	defer c.lock.Unlock()
+	if c.currentSession == sess {
+		c.currentSession = nil
+		c.sessionWaiter = nil
+		// a late answer destroys the session on the router
+		sess.setStateLocked(SESSION_STATE_DESTROYED)
+	}
+}
+
+// DestroySession asks the router to destroy sess and waits until it did.
+func (c *Client) DestroySession(sess *Session) error {
+	return c.DestroySessionContext(context.Background(), sess)
+}
+
+// DestroySessionContext is DestroySession bounded by ctx. Messages queued
+// before it are sent to the router before the session is destroyed.
+func (c *Client) DestroySessionContext(ctx context.Context, sess *Session) error {
+	if !c.IsConnected() {
+		return ErrNotConnected
+	}
+	c.lock.Lock()
+	if c.sessions[sess.id] != sess {
+		c.lock.Unlock()
+		return &UnknownSessionError{SessionId: sess.id, Type: I2CP_MSG_DESTROY_SESSION}
+	}
+	waiter, ok := c.destroyWaiters[sess.id]
+	if !ok {
+		waiter = make(chan struct{})
+		c.destroyWaiters[sess.id] = waiter
+	}
+	done := c.done
+	c.lock.Unlock()
+	if !ok {
+		if err := c.msgDestroySession(sess, true); err != nil {
 			return err
 		}
-		if ret == 0 {
-			break
+	}
+	select {
+	case <-waiter:
+		return nil
+	case <-done:
+		// the router drops the sessions of a closed connection
+		c.forgetSession(sess)
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ReconfigureSessionContext applies changes to the config of sess on the
+// router, see Session.Reconfigure.
+func (c *Client) ReconfigureSessionContext(ctx context.Context, sess *Session, changes map[SessionConfigProperty]string) error {
+	for prop := range changes {
+		if prop < 0 || prop >= NR_OF_SESSION_CONFIG_PROPERTIES {
+			return fmt.Errorf("i2cp: invalid session config property %d", prop)
+		}
+	}
+	if !c.IsConnected() {
+		return ErrNotConnected
+	}
+	sess.reconfigureLock.Lock()
+	defer sess.reconfigureLock.Unlock()
+	c.lock.Lock()
+	if c.sessions[sess.id] != sess {
+		c.lock.Unlock()
+		return &UnknownSessionError{SessionId: sess.id, Type: I2CP_MSG_RECONFIGURE_SESSION}
+	}
+	previous := sess.config.properties
+	for prop, value := range changes {
+		sess.config.properties[prop] = value
+	}
+	waiter := make(chan SessionStatus, 1)
+	c.reconfigureWaiters[sess.id] = waiter
+	done := c.done
+	c.lock.Unlock()
+	rollback := func() {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		sess.config.properties = previous
+		if c.reconfigureWaiters[sess.id] == waiter {
+			delete(c.reconfigureWaiters, sess.id)
+		}
+	}
+	if err := c.msgReconfigureSession(sess, true); err != nil {
+		rollback()
+		return err
+	}
+	select {
+	case status, ok := <-waiter:
+		if !ok {
+			// the connection was lost, the session is re-created with
+			// the new config
+			return ErrRouterDisconnected
+		}
+		if status != I2CP_SESSION_STATUS_UPDATED {
+			Warning(TAG, "Router refused to reconfigure session %d: %s", c.sessionId(sess), status)
+			rollback()
+			return ErrReconfigureRefused
 		}
+		return nil
+	case <-done:
+		rollback()
+		return ErrNotConnected
+	case <-ctx.Done():
+		rollback()
+		return ctx.Err()
 	}
+}
+
+// resolveReconfigureWaiter wakes up Reconfigure once the router answered.
+func (c *Client) resolveReconfigureWaiter(sessionID uint16, status SessionStatus) {
+	c.lock.Lock()
+	waiter, ok := c.reconfigureWaiters[sessionID]
+	delete(c.reconfigureWaiters, sessionID)
 	c.lock.Unlock()
-	var err error
-	for c.tcp.CanRead() {
-		if err = c.recvMessage(I2CP_MSG_ANY, c.messageStream, true); err != nil {
-			return err
+	if ok {
+		waiter <- status
+	}
+}
+
+// DestinationLookup starts resolving address, the result is passed to the
+// session's onDestination callback with the returned request id.
+func (c *Client) DestinationLookup(session *Session, address string) (requestId uint32, err error) {
+	return c.lookupDestination(session, address, defaultLookupTimeout, nil)
+}
+
+// LookupContext resolves address, a host name or b32 address, and waits for
+// the router's answer. The lookup timeout sent to the router is taken from
+// the deadline of ctx when there is one.
+func (c *Client) LookupContext(ctx context.Context, session *Session, address string) (*Destination, error) {
+	if !c.IsConnected() {
+		return nil, ErrNotConnected
+	}
+	timeout := defaultLookupTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		if ms := time.Until(deadline) / time.Millisecond; ms > 0 && ms < time.Duration(timeout) {
+			timeout = uint32(ms)
 		}
 	}
-	return err
+	waiter := make(chan *Destination, 1)
+	requestId, err := c.lookupDestination(session, address, timeout, waiter)
+	if err != nil {
+		return nil, err
+	}
+	c.lock.Lock()
+	done := c.done
+	c.lock.Unlock()
+	select {
+	case dest, ok := <-waiter:
+		if !ok {
+			// the connection was lost, dropPending gave up the lookup
+			return nil, ErrRouterDisconnected
+		}
+		if dest == nil {
+			return nil, ErrLookupFailed
+		}
+		return dest, nil
+	case <-done:
+		c.cancelLookup(requestId, address)
+		return nil, ErrNotConnected
+	case <-ctx.Done():
+		c.cancelLookup(requestId, address)
+		return nil, ctx.Err()
+	}
 }
 
-func (c *Client) DestinationLookup(session *Session, address string) (requestId uint32) {
+// cancelLookup removes a lookup nobody waits for anymore, a late reply is
+// dropped.
+func (c *Client) cancelLookup(requestId uint32, address string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.lookupReq, requestId)
+	if c.lookup[address] == requestId {
+		delete(c.lookup, address)
+	}
+}
+
+func (c *Client) lookupDestination(session *Session, address string, timeout uint32, waiter chan *Destination) (requestId uint32, err error) {
 	var out *Stream
 	var lup LookupEntry
 	b32Len := 52 + 8
-	defaultTimeout := uint32(30000)
-	routerCanHostLookup := (c.router.capabilities & ROUTER_CAN_HOST_LOOKUP) == ROUTER_CAN_HOST_LOOKUP
-	if !routerCanHostLookup && len(address) != b32Len {
+	isB32 := len(address) == b32Len && strings.HasSuffix(address, ".b32.i2p")
+	routerCanHostLookup := c.RouterInfo().Supports(ROUTER_CAN_HOST_LOOKUP)
+	if !routerCanHostLookup && !isB32 {
 		Warning(TAG, "Address '%s' is not a b32 address %d.", address, len(address))
-		return
+		return 0, ErrLookupUnsupported
 	}
-	in := NewStream(make([]byte, 512))
-	if len(address) == b32Len {
+	if isB32 {
 		Debug(TAG, "Lookup of b32 address detected, decode and use hash for faster lookup.")
-		host := address[:strings.Index(address, ".")]
-		in.Write([]byte(host))
-		out, _ = GetCryptoInstance().DecodeStream(CODEC_BASE32, in)
-		if out.Len() == 0 {
+		host := strings.TrimSuffix(address, ".b32.i2p")
+		var decodeErr error
+		out, decodeErr = GetCryptoInstance().DecodeStream(CODEC_BASE32, NewStream([]byte(host)))
+		if decodeErr != nil || out.Len() != 32 {
 			Warning(TAG, "Failed to decode hash of address '%s'", address)
+			// routers that look up host names resolve b32 addresses too
+			if !routerCanHostLookup {
+				return 0, ErrLookupFailed
+			}
+			out = nil
 		}
 	}
-	lup = LookupEntry{address: address, session: session}
-	c.lookupRequestId += 1
-	requestId = c.lookupRequestId
+	lup = LookupEntry{address: address, session: session, waiter: waiter}
+	requestId = c.lookupRequestId.Add(1)
+	c.lock.Lock()
 	c.lookupReq[requestId] = lup
+	if !routerCanHostLookup {
+		c.lookup[address] = requestId
+	}
+	c.lock.Unlock()
 	if routerCanHostLookup {
-		if out == nil || out.Len() == 0 {
-			c.msgHostLookup(session, requestId, defaultTimeout, HOST_LOOKUP_TYPE_HOST, []byte(address), true)
+		if out == nil {
+			err = c.msgHostLookup(session, requestId, timeout, HOST_LOOKUP_TYPE_HOST, []byte(address), true)
 		} else {
-			c.msgHostLookup(session, requestId, defaultTimeout, HOST_LOOKUP_TYPE_HASH, out.Bytes(), true)
+			err = c.msgHostLookup(session, requestId, timeout, HOST_LOOKUP_TYPE_HASH, out.Bytes(), true)
 		}
 	} else {
-		c.lookup[address] = requestId
-		c.msgDestLookup(out.Bytes(), true)
+		err = c.msgDestLookup(out.Bytes(), true)
 	}
-	return requestId
+	if err != nil {
+		c.cancelLookup(requestId, address)
+		return 0, err
+	}
+	return requestId, nil
 }
 
-func (c *Client) Disconnect() {
+// Close shuts the client down gracefully: queued messages are sent, every
+// session is destroyed and the router is told about the disconnect before
+// the transport is closed. The transport is closed even if ctx expires first.
+func (c *Client) Close(ctx context.Context) (err error) {
+	if !c.IsConnected() {
+		return c.Disconnect()
+	}
+	c.lock.Lock()
+	// no reconnecting from here on
+	c.closed = true
+	sessions := make([]*Session, 0, len(c.sessions))
+	for _, sess := range c.sessions {
+		// subsessions are destroyed with their primary
+		if sess.primary == nil {
+			sessions = append(sessions, sess)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].id < sessions[j].id })
+	c.lock.Unlock()
+	var errs []error
+	// DestroySession jumps the queue, send the messages of the sessions first
+	if err = c.flushOutputQueue(); err != nil {
+		errs = append(errs, err)
+	}
+	for _, sess := range sessions {
+		c.lock.Lock()
+		// a real router makes every further session on the connection a
+		// subsession, destroying the first one took the others with it
+		gone := c.sessions[sess.id] != sess
+		c.lock.Unlock()
+		if gone {
+			continue
+		}
+		if err = c.DestroySessionContext(ctx, sess); err != nil {
+			errs = append(errs, fmt.Errorf("i2cp: destroying session %d: %w", c.sessionId(sess), err))
+			if ctx.Err() != nil {
+				break
+			}
+		}
+	}
+	if c.IsConnected() {
+		if err = c.msgDisconnect("client closed", true); err == nil {
+			err = c.flushOutputQueue()
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err = c.Disconnect(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// Disconnect closes the connection to the router right away, use Close to
+// destroy the sessions first.
+func (c *Client) Disconnect() (err error) {
 	Info(TAG, "Disconnection client %p", c)
-	c.tcp.Disconnect()
+	c.lock.Lock()
+	c.closed = true
+	c.lock.Unlock()
+	err = c.transport.Close()
+	c.stopIO(nil)
+	return
+}
+
+// SetTransport replaces the transport used to reach the router, it must be
+// called before Connect. The i2cp.tcp.* and i2cp.SSL properties only apply to
+// the default Tcp transport.
+func (c *Client) SetTransport(transport Transport) {
+	c.transport = transport
 }
 
 func (c *Client) SetProperty(name, value string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
 	if _, ok := c.properties[name]; ok {
 		c.properties[name] = value
 		switch name {
@@ -629,12 +1169,45 @@ func (c *Client) SetProperty(name, value string) {
 			c.tcp.SetProperty(TCP_PROP_ADDRESS, c.properties[name])
 		case "i2cp.tcp.port":
 			c.tcp.SetProperty(TCP_PROP_PORT, c.properties[name])
+		case "i2cp.tcp.fallback":
+			c.tcp.SetProperty(TCP_PROP_FALLBACK_ADDRESSES, c.properties[name])
+		case "i2cp.tcp.proxy":
+			c.tcp.SetProperty(TCP_PROP_PROXY, c.properties[name])
 		case "i2cp.SSL":
 			c.tcp.SetProperty(TCP_PROP_USE_TLS, c.properties[name])
+		case "i2cp.SSL.caFile":
+			c.tcp.SetProperty(TCP_PROP_TLS_CA_FILE, c.properties[name])
+		case "i2cp.SSL.clientCertificate":
+			c.tcp.SetProperty(TCP_PROP_TLS_CLIENT_CERTIFICATE, c.properties[name])
+		case "i2cp.SSL.clientKey":
+			c.tcp.SetProperty(TCP_PROP_TLS_CLIENT_KEY, c.properties[name])
+		case "i2cp.SSL.fingerprint":
+			c.tcp.SetProperty(TCP_PROP_TLS_PINNED_FINGERPRINT, c.properties[name])
 		}
 	}
 }
 
+// RouterEndpoint returns the host:port of the router the client connected to,
+// or an empty string when a non TCP transport is used.
+func (c *Client) RouterEndpoint() string {
+	if t, ok := c.transport.(interface{ Endpoint() string }); ok {
+		return t.Endpoint()
+	}
+	return ""
+}
+
+// IsConnected reports whether the client's I/O is running and not waiting
+// for a reconnect.
 func (c *Client) IsConnected() bool {
-	return c.tcp.IsConnected()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.done == nil || c.reconnecting {
+		return false
+	}
+	select {
+	case <-c.done:
+		return false
+	default:
+		return true
+	}
 }
@@ -1,33 +1,477 @@
 package go_i2cp
 
 import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/wkoomson/go-i2cp/i2cptest"
+	"github.com/wkoomson/go-i2cp/message"
 )
 
-func TestClient(t *testing.T) {
+// startRouter serves a fake router on a local port and returns a client
+// configured to connect to it.
+func startRouter(t *testing.T) (*i2cptest.Router, *Client) {
+	router := i2cptest.NewRouter()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %s", err.Error())
+	}
+	go router.Serve(ln)
+	t.Cleanup(func() { router.Close() })
 	client := NewClient(nil)
-	client.Connect()
-	client.Disconnect()
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	client.SetProperty("i2cp.tcp.host", host)
+	client.SetProperty("i2cp.tcp.port", port)
+	return router, client
 }
 
-func TestClient_CreateSession(t *testing.T) {
-	client := NewClient(nil)
-	client.Connect()
-	session := NewSession(client, SessionCallbacks{
-		onDestination: func(session *Session, requestId uint32, address string, dest *Destination) {
+func TestClient(t *testing.T) {
+	_, client := startRouter(t)
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Could not connect: %s", err.Error())
+	}
+	if client.RouterInfo().Version.Compare(Version{Major: 0, Minor: 9, Micro: 33}) != 0 {
+		t.Fatalf("Unexpected router version %s", client.RouterInfo().Version)
+	}
+	if err := client.Disconnect(); err != nil {
+		t.Fatalf("Could not disconnect: %s", err.Error())
+	}
+}
 
-		},
+func TestClient_CreateSession(t *testing.T) {
+	router, client := startRouter(t)
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Could not connect: %s", err.Error())
+	}
+	defer client.Disconnect()
+	statuses := make(chan SessionStatus, 4)
+	session, err := NewSession(client, SessionCallbacks{
 		onStatus: func(session *Session, status SessionStatus) {
-
-		},
-		onMessage: func(session *Session, protocol uint8, srcPort, destPort uint16, payload *Stream) {
-
+			statuses <- status
 		},
 	})
+	if err != nil {
+		t.Fatal(err)
+	}
 	session.config.SetProperty(SESSION_CONFIG_PROP_I2CP_FAST_RECEIVE, "true")
 	session.config.SetProperty(SESSION_CONFIG_PROP_OUTBOUND_NICKNAME, "test-i2cp")
 	session.config.SetProperty(SESSION_CONFIG_PROP_OUTBOUND_QUANTITY, "4")
-	session.config.destination, _ = NewDestinationFromBase64("r2zbc34IQSzOIF4N0enKf0xXkJKgsj9yTGGspRnstKZf~4UoAljZOW5aFZywGo-NlaXwt~tIyj4NC0Til0vl1D5N9ip7OMYUCajNNgiXEH~FN33yl-AcJbeTlB-FychSmVfYciTQj6yd19~6wICwkdpy6AYo90bAejSVGpvtFeP5P2pnSwPmcB8m79wyq~C2XjQCe5UcBxnfYolWKgr3uDFrgbhqBVCCkO7zTiARwOWZLVOvZsvKZR4WvYAmQI6CQaxnmT5n1FKO6NBb-HOxVw4onERq86Sc6EQ5d48719Yk-73wq1Mxmr7Y2UwmL~FCnY33rT1FJY2KzUENICL1uEuiVmr9N924CT9RbtldOUUcXmM1gaHlPS40-Hz4AvPxFXHynbyySktN3hBLPwfwhyIQw95ezSNuiBB0xPcujazCw02103n2CO-59rMDmWpttLjpLMggP9IwsAPa9FVLnBqfuCn3NrC4fia50RDwfR41AD1GOOWiUT0avYzbbOdsAAAA")
-	client.CreateSession(session)
+	if err = client.CreateSession(session); err != nil {
+		t.Fatalf("Could not create session: %s", err.Error())
+	}
+	if status := <-statuses; status != I2CP_SESSION_STATUS_CREATED {
+		t.Fatalf("Expected status created, got %d", status)
+	}
+	if router.Sessions() != 1 {
+		t.Fatalf("Expected 1 session on the router, got %d", router.Sessions())
+	}
+}
+
+func TestClient_SendMessage(t *testing.T) {
+	_, client := startRouter(t)
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Could not connect: %s", err.Error())
+	}
+	defer client.Disconnect()
+	type message struct {
+		protocol          uint8
+		srcPort, destPort uint16
+		payload           string
+	}
+	received := make(chan message, 1)
+	sender, _ := NewSession(client, SessionCallbacks{})
+	receiver, _ := NewSession(client, SessionCallbacks{
+		onMessage: func(session *Session, protocol uint8, srcPort, destPort uint16, payload *Stream) {
+			received <- message{protocol, srcPort, destPort, string(payload.Bytes())}
+		},
+	})
+	for _, session := range []*Session{sender, receiver} {
+		if err := client.CreateSession(session); err != nil {
+			t.Fatalf("Could not create session: %s", err.Error())
+		}
+	}
+	if err := sender.SendMessage(receiver.Destination(), PROTOCOL_DATAGRAM, 1234, 5678, NewStream([]byte("hello i2p")), 1); err != nil {
+		t.Fatalf("Could not send message: %s", err.Error())
+	}
+	select {
+	case msg := <-received:
+		expected := message{PROTOCOL_DATAGRAM, 1234, 5678, "hello i2p"}
+		if msg != expected {
+			t.Fatalf("Expected %+v, got %+v", expected, msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Message was not delivered")
+	}
+}
+
+func TestClient_LookupContext(t *testing.T) {
+	router, client := startRouter(t)
+	known, err := NewDestination()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := NewStream(make([]byte, 0, DEST_SIZE))
+	known.WriteToMessage(stream)
+	router.AddHost("known.i2p", stream.Bytes())
+	if err = client.Connect(); err != nil {
+		t.Fatalf("Could not connect: %s", err.Error())
+	}
+	defer client.Disconnect()
+	session, _ := NewSession(client, SessionCallbacks{})
+	if err = client.CreateSession(session); err != nil {
+		t.Fatalf("Could not create session: %s", err.Error())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	dest, err := client.LookupContext(ctx, session, "known.i2p")
+	if err != nil {
+		t.Fatalf("Could not look up known.i2p: %s", err.Error())
+	}
+	if dest.b32 != known.b32 {
+		t.Fatalf("Expected %s, got %s", known.b32, dest.b32)
+	}
+	if _, err = client.LookupContext(ctx, session, "unknown.i2p"); err != ErrLookupFailed {
+		t.Fatalf("Expected ErrLookupFailed, got %v", err)
+	}
+}
+
+func TestClient_Reconnect(t *testing.T) {
+	router, client := startRouter(t)
+	events := make(chan ReconnectEvent, 16)
+	client.callbacks = &ClientCallBacks{
+		OnReconnect: func(client *Client, event ReconnectEvent) {
+			events <- event
+		},
+	}
+	client.SetReconnectPolicy(&ReconnectPolicy{InitialDelay: 10 * time.Millisecond, MaxDelay: 10 * time.Millisecond, Multiplier: 1, MaxAttempts: 5})
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Could not connect: %s", err.Error())
+	}
+	defer client.Disconnect()
+	session, _ := NewSession(client, SessionCallbacks{})
+	if err := client.CreateSession(session); err != nil {
+		t.Fatalf("Could not create session: %s", err.Error())
+	}
+	router.Disconnect("router restart")
+	for {
+		select {
+		case event := <-events:
+			if event.Type == RECONNECT_GAVE_UP {
+				t.Fatalf("Client gave up reconnecting: %v", event.Err)
+			}
+			if event.Type != RECONNECT_SUCCEEDED {
+				continue
+			}
+			if router.Sessions() != 1 {
+				t.Fatalf("Expected the session to be restored, router has %d sessions", router.Sessions())
+			}
+			return
+		case <-time.After(5 * time.Second):
+			t.Fatal("Client did not reconnect")
+		}
+	}
+}
+
+func TestClient_LookupMalformedB32(t *testing.T) {
+	_, client := startRouter(t)
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Could not connect: %s", err.Error())
+	}
+	defer client.Disconnect()
+	session, _ := NewSession(client, SessionCallbacks{})
+	if err := client.CreateSession(session); err != nil {
+		t.Fatalf("Could not create session: %s", err.Error())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	// b32 sized addresses that don't decode are looked up as host names
+	for _, address := range []string{strings.Repeat("a", 60), strings.Repeat("!", 52) + ".b32.i2p"} {
+		if _, err := client.LookupContext(ctx, session, address); err != ErrLookupFailed {
+			t.Fatalf("Expected ErrLookupFailed for %s, got %v", address, err)
+		}
+	}
+	// routers without HostLookup get no lookup for a hash that doesn't decode
+	client.lock.Lock()
+	client.router.Capabilities &^= ROUTER_CAN_HOST_LOOKUP
+	client.lock.Unlock()
+	if _, err := client.LookupContext(ctx, session, strings.Repeat("!", 52)+".b32.i2p"); err != ErrLookupFailed {
+		t.Fatalf("Expected ErrLookupFailed, got %v", err)
+	}
+}
+
+func TestClient_ReconnectSuspendsSessions(t *testing.T) {
+	router, client := startRouter(t)
+	started := make(chan struct{}, 1)
+	client.callbacks = &ClientCallBacks{
+		OnReconnect: func(client *Client, event ReconnectEvent) {
+			if event.Type == RECONNECT_STARTED {
+				started <- struct{}{}
+			}
+		},
+	}
+	client.SetReconnectPolicy(&ReconnectPolicy{InitialDelay: time.Minute, MaxDelay: time.Minute, Multiplier: 1})
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Could not connect: %s", err.Error())
+	}
+	defer client.Disconnect()
+	session, _ := NewSession(client, SessionCallbacks{})
+	if err := client.CreateSession(session); err != nil {
+		t.Fatalf("Could not create session: %s", err.Error())
+	}
+	router.Disconnect("router restart")
+	<-started
+	// the message would be sent with the old session id after the reconnect
+	err := session.SendMessage(session.Destination(), PROTOCOL_RAW_DATAGRAM, 0, 0, NewStream([]byte("hello")), 0)
+	var stateErr *SessionStateError
+	if !errors.As(err, &stateErr) || stateErr.State != SESSION_STATE_PENDING {
+		t.Fatalf("Expected a SessionStateError in state PENDING, got %v", err)
+	}
+}
+
+func TestClient_ReconnectFailsLookups(t *testing.T) {
+	router, client := startRouter(t)
+	var dropped atomic.Bool
+	router.OnReceive = func(typ uint8, body []byte) {
+		// the router goes away before answering the lookup
+		if typ == I2CP_MSG_HOST_LOOKUP && dropped.CompareAndSwap(false, true) {
+			router.Disconnect("router restart")
+		}
+	}
+	client.SetReconnectPolicy(&ReconnectPolicy{InitialDelay: 10 * time.Millisecond, MaxDelay: 10 * time.Millisecond, Multiplier: 1, MaxAttempts: 5})
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Could not connect: %s", err.Error())
+	}
+	defer client.Disconnect()
+	session, _ := NewSession(client, SessionCallbacks{})
+	if err := client.CreateSession(session); err != nil {
+		t.Fatalf("Could not create session: %s", err.Error())
+	}
+	result := make(chan error, 1)
+	go func() {
+		_, err := client.LookupContext(context.Background(), session, "unknown.i2p")
+		result <- err
+	}()
+	select {
+	case err := <-result:
+		if !errors.Is(err, ErrRouterDisconnected) {
+			t.Fatalf("Expected ErrRouterDisconnected, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("LookupContext did not return after the connection was lost")
+	}
+}
+
+func TestClient_ReconnectFailsCreateSession(t *testing.T) {
+	router, client := startRouter(t)
+	var creates atomic.Int32
+	router.OnReceive = func(typ uint8, body []byte) {
+		// the router goes away before answering the second session
+		if typ == I2CP_MSG_CREATE_SESSION && creates.Add(1) == 2 {
+			router.Disconnect("router restart")
+		}
+	}
+	client.SetReconnectPolicy(&ReconnectPolicy{InitialDelay: 10 * time.Millisecond, MaxDelay: 10 * time.Millisecond, Multiplier: 1, MaxAttempts: 5})
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Could not connect: %s", err.Error())
+	}
+	defer client.Disconnect()
+	restored, _ := NewSession(client, SessionCallbacks{})
+	if err := client.CreateSession(restored); err != nil {
+		t.Fatalf("Could not create session: %s", err.Error())
+	}
+	pending, _ := NewSession(client, SessionCallbacks{})
+	result := make(chan error, 1)
+	go func() { result <- client.CreateSession(pending) }()
+	select {
+	case err := <-result:
+		// the status of the restored session must not be taken for it
+		if !errors.Is(err, ErrRouterDisconnected) {
+			t.Fatalf("Expected ErrRouterDisconnected, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("CreateSession did not return after the connection was lost")
+	}
+	if state := pending.State(); state != SESSION_STATE_INVALID {
+		t.Fatalf("Expected the pending session to be invalid, got %s", state)
+	}
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	for _, sess := range client.sessions {
+		if sess == pending {
+			t.Fatal("The pending session was registered after the reconnect")
+		}
+	}
+}
+
+func TestClient_ReconnectInterrupted(t *testing.T) {
+	router, client := startRouter(t)
+	started := make(chan struct{}, 1)
+	client.callbacks = &ClientCallBacks{
+		OnReconnect: func(client *Client, event ReconnectEvent) {
+			if event.Type == RECONNECT_STARTED {
+				started <- struct{}{}
+			}
+		},
+	}
+	client.SetReconnectPolicy(&ReconnectPolicy{InitialDelay: time.Minute, MaxDelay: time.Minute, Multiplier: 1})
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Could not connect: %s", err.Error())
+	}
+	router.Disconnect("router restart")
+	<-started
 	client.Disconnect()
+	// Disconnect ends the backoff instead of waiting for the next attempt
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		client.lock.Lock()
+		reconnecting := client.reconnecting
+		client.lock.Unlock()
+		if !reconnecting {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Disconnect did not interrupt the reconnect backoff")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestClient_ReconnectAttemptTimeout(t *testing.T) {
+	router, client := startRouter(t)
+	var getDates atomic.Int32
+	stalled := make(chan struct{})
+	t.Cleanup(func() { close(stalled) })
+	router.OnReceive = func(typ uint8, body []byte) {
+		// the restarted router accepts the connection but never answers
+		if typ == I2CP_MSG_GET_DATE && getDates.Add(1) > 1 {
+			<-stalled
+		}
+	}
+	failed := make(chan error, 4)
+	client.callbacks = &ClientCallBacks{
+		OnReconnect: func(client *Client, event ReconnectEvent) {
+			if event.Type == RECONNECT_ATTEMPT_FAILED {
+				failed <- event.Err
+			}
+		},
+	}
+	client.SetReconnectPolicy(&ReconnectPolicy{InitialDelay: 10 * time.Millisecond, MaxDelay: 10 * time.Millisecond, Multiplier: 1, MaxAttempts: 1, AttemptTimeout: 50 * time.Millisecond})
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Could not connect: %s", err.Error())
+	}
+	defer client.Disconnect()
+	router.Disconnect("router restart")
+	select {
+	case err := <-failed:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Expected the attempt to time out, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Reconnect attempt did not time out")
+	}
+}
+
+func TestClient_ConnectContextTimeout(t *testing.T) {
+	client := NewClient(nil)
+	pipe, router := NewPipe()
+	defer router.Close()
+	// a router that never answers GetDate
+	go io.Copy(io.Discard, router)
+	client.SetTransport(pipe)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := client.ConnectContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Expected deadline exceeded, got %v", err)
+	}
+	if client.IsConnected() {
+		t.Fatal("Client should not be connected after a failed handshake")
+	}
+}
+
+func TestClient_CreateSessionContextNotConnected(t *testing.T) {
+	client := NewClient(nil)
+	session, _ := NewSession(client, SessionCallbacks{})
+	if err := client.CreateSessionContext(context.Background(), session); err != ErrNotConnected {
+		t.Fatalf("Expected ErrNotConnected, got %v", err)
+	}
+}
+
+func TestClient_ConnectContextHandshake(t *testing.T) {
+	client := NewClient(nil)
+	pipe, router := NewPipe()
+	defer router.Close()
+	go func() {
+		protocol := make([]byte, 1)
+		if _, err := io.ReadFull(router, protocol); err != nil {
+			return
+		}
+		if _, _, err := message.ReadFrame(router, I2CP_MESSAGE_SIZE); err != nil {
+			return
+		}
+		message.Write(router, &message.SetDate{Date: uint64(time.Now().Unix() * 1000), Version: "0.9.33"})
+		io.Copy(io.Discard, router)
+	}()
+	client.SetTransport(pipe)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.ConnectContext(ctx); err != nil {
+		t.Fatalf("Could not connect: %s", err.Error())
+	}
+	defer client.Disconnect()
+	if !client.IsConnected() {
+		t.Fatal("Client should be connected after the handshake")
+	}
+	if client.RouterInfo().Version.Compare(Version{Major: 0, Minor: 9, Micro: 33}) != 0 {
+		t.Fatalf("Unexpected router version %s", client.RouterInfo().Version)
+	}
+}
+
+func TestClient_Close(t *testing.T) {
+	router, client := startRouter(t)
+	received := make(chan uint8, 16)
+	router.OnReceive = func(typ uint8, body []byte) {
+		received <- typ
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.ConnectContext(ctx); err != nil {
+		t.Fatalf("Could not connect: %s", err.Error())
+	}
+	session, err := NewSession(client, SessionCallbacks{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = client.CreateSessionContext(ctx, session); err != nil {
+		t.Fatalf("Could not create session: %s", err.Error())
+	}
+	expect := func(types ...uint8) {
+		for _, typ := range types {
+			select {
+			case got := <-received:
+				if got != typ {
+					t.Fatalf("Expected message type %d, got %d", typ, got)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("Router did not receive message type %d", typ)
+			}
+		}
+	}
+	// the lease set is sent after CreateSession returned
+	expect(I2CP_MSG_GET_DATE, I2CP_MSG_CREATE_SESSION, I2CP_MSG_CREATE_LEASE_SET)
+	if err = client.Close(ctx); err != nil {
+		t.Fatalf("Could not close client: %s", err.Error())
+	}
+	if client.IsConnected() {
+		t.Fatal("Client should be disconnected after Close")
+	}
+	if len(client.sessions) != 0 {
+		t.Fatalf("Expected no sessions after Close, got %d", len(client.sessions))
+	}
+	expect(I2CP_MSG_DESTROY_SESSION, I2CP_MSG_DISCONNECT)
 }
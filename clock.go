@@ -0,0 +1,37 @@
+package go_i2cp
+
+import "time"
+
+// clockSkewWarning is the clock offset above which a warning is logged.
+const clockSkewWarning = time.Minute
+
+// updateClockOffset derives the offset of the router's clock from a SetDate
+// that arrived at received, rtt is the round trip of the GetDate it answers.
+// The router read its clock about half a round trip before the answer
+// arrived.
+func (c *Client) updateClockOffset(routerDate, received time.Time, rtt time.Duration) {
+	offset := routerDate.Sub(received.Add(-rtt / 2))
+	c.lock.Lock()
+	c.clockOffset = offset
+	c.lock.Unlock()
+	if offset > clockSkewWarning || offset < -clockSkewWarning {
+		Warning(TAG, "Router clock differs from the local clock by %s", offset)
+	} else {
+		Debug(TAG, "Router clock offset %s", offset)
+	}
+}
+
+// ClockOffset returns how far the router's clock is ahead of the local one,
+// as measured by the latest GetDate round trip.
+func (c *Client) ClockOffset() time.Duration {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.clockOffset
+}
+
+// Now returns the current time on the router's clock. Timestamps the client
+// sends to the router, like the date of a session config, use it so skewed
+// local clocks don't get messages rejected.
+func (c *Client) Now() time.Time {
+	return time.Now().Add(c.ClockOffset())
+}
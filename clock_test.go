@@ -0,0 +1,46 @@
+package go_i2cp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/wkoomson/go-i2cp/message"
+)
+
+func TestClient_ClockOffset(t *testing.T) {
+	router, client := startRouter(t)
+	skew := time.Hour
+	router.Now = func() time.Time { return time.Now().Add(skew) }
+	dates := make(chan uint64, 1)
+	router.OnReceive = func(typ uint8, body []byte) {
+		if typ == I2CP_MSG_CREATE_SESSION {
+			m, err := message.Decode(typ, body)
+			if err == nil {
+				dates <- m.(*message.CreateSession).Date
+			}
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.ConnectContext(ctx); err != nil {
+		t.Fatalf("Could not connect: %s", err.Error())
+	}
+	defer client.Disconnect()
+	near := func(what string, got, expected time.Time) {
+		if d := got.Sub(expected); d > time.Second || d < -time.Second {
+			t.Fatalf("Expected %s %s, got %s", what, expected, got)
+		}
+	}
+	if offset := client.ClockOffset(); offset < skew-time.Second || offset > skew+time.Second {
+		t.Fatalf("Expected a clock offset of about %s, got %s", skew, offset)
+	}
+	near("router time", client.Now(), time.Now().Add(skew))
+
+	session, _ := NewSession(client, SessionCallbacks{})
+	if err := client.CreateSessionContext(ctx, session); err != nil {
+		t.Fatalf("Could not create session: %s", err.Error())
+	}
+	date := <-dates
+	near("session config date", time.Unix(0, int64(date)*int64(time.Millisecond)), time.Now().Add(skew))
+}
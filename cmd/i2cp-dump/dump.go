@@ -0,0 +1,397 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	i2cp "github.com/wkoomson/go-i2cp"
+	"github.com/wkoomson/go-i2cp/capture"
+	"github.com/wkoomson/go-i2cp/message"
+)
+
+// payloadPreview is the number of decompressed payload bytes printed.
+const payloadPreview = 64
+
+var (
+	b32Encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+	// b64Encoding is the I2P base64 alphabet
+	b64Encoding = base64.NewEncoding("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-~")
+)
+
+// parseHex decodes a hex dump, whitespace and "#" comments are skipped.
+func parseHex(data []byte) ([]byte, error) {
+	var digits []byte
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if i := bytes.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		for _, c := range line {
+			if !unicode.IsSpace(rune(c)) {
+				digits = append(digits, c)
+			}
+		}
+	}
+	raw := make([]byte, hex.DecodedLen(len(digits)))
+	if _, err := hex.Decode(raw, digits); err != nil {
+		return nil, fmt.Errorf("invalid hex dump: %w", err)
+	}
+	return raw, nil
+}
+
+// splitFrames cuts a raw byte stream into records without time and
+// direction, skipping the protocol byte a client sends first.
+func splitFrames(raw []byte) (records []capture.Record, err error) {
+	if len(raw) > 0 && raw[0] == i2cp.I2CP_PROTOCOL_INIT {
+		raw = raw[1:]
+	}
+	r := bytes.NewReader(raw)
+	for r.Len() > 0 {
+		var rec capture.Record
+		if rec.Type, rec.Body, err = message.ReadFrame(r, message.MaxSize); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return records, fmt.Errorf("frame %d at offset %d: %w", len(records), len(raw)-r.Len(), err)
+		}
+		records = append(records, rec)
+	}
+	return
+}
+
+// dumper prints records in a human readable form.
+type dumper struct {
+	w io.Writer
+	// now is the time of the record being printed, zero for hex dumps
+	now time.Time
+	// malformed counts the records that didn't decode
+	malformed int
+}
+
+func (d *dumper) dump(i int, rec capture.Record) {
+	d.now = rec.Time
+	header := fmt.Sprintf("#%d", i)
+	if !rec.Time.IsZero() {
+		header += " " + rec.Time.UTC().Format("15:04:05.000000")
+	}
+	if rec.Direction != 0 {
+		header += " " + rec.Direction.String()
+	}
+	fmt.Fprintf(d.w, "%s %s (%d), %d bytes\n", header, message.Name(rec.Type), rec.Type, len(rec.Body))
+	m, err := rec.Decode()
+	if err != nil {
+		d.malformed++
+		d.field("error", "%s", err.Error())
+		d.hexDump(rec.Body)
+		return
+	}
+	d.message(m)
+}
+
+func (d *dumper) message(m message.Message) {
+	switch m := m.(type) {
+	case *message.CreateSession:
+		d.sessionConfig(&m.SessionConfig)
+	case *message.ReconfigureSession:
+		d.field("session", "%d", m.SessionId)
+		d.sessionConfig(&m.SessionConfig)
+	case *message.DestroySession:
+		d.field("session", "%d", m.SessionId)
+	case *message.CreateLeaseSet:
+		d.field("session", "%d", m.SessionId)
+		d.field("signing key", "%d bytes", len(m.SigningPrivateKey))
+		d.field("private key", "%d bytes", len(m.PrivateKey))
+		d.leaseSet(m.LeaseSet)
+	case *message.SendMessage:
+		d.sendMessage(m)
+	case *message.SendMessageExpires:
+		d.sendMessage(&m.SendMessage)
+		d.field("flags", "%#04x", m.Flags)
+		d.field("expiration", "%s", d.date(m.Expiration))
+	case *message.ReceiveMessageBegin:
+		d.field("session", "%d", m.SessionId)
+		d.field("message", "%d", m.MessageId)
+	case *message.ReceiveMessageEnd:
+		d.field("session", "%d", m.SessionId)
+		d.field("message", "%d", m.MessageId)
+	case *message.SessionStatus:
+		d.field("session", "%d", m.SessionId)
+		status := i2cp.SessionStatus(m.Status).String()
+		if m.Status == message.SessionRefused {
+			status = "REFUSED"
+		}
+		d.field("status", "%s (%d)", status, m.Status)
+	case *message.RequestLeaseSet:
+		d.field("session", "%d", m.SessionId)
+		for i, tunnel := range m.Tunnels {
+			d.field(fmt.Sprintf("tunnel %d", i), "gateway %s tunnel %d", hash(tunnel.Router), tunnel.TunnelId)
+		}
+		d.field("end date", "%s", d.date(m.EndDate))
+	case *message.MessageStatus:
+		d.field("session", "%d", m.SessionId)
+		d.field("message", "%d", m.MessageId)
+		d.field("status", "%s (%d)", i2cp.SessionMessageStatus(m.Status), m.Status)
+		d.field("size", "%d", m.Size)
+		d.field("nonce", "%d", m.Nonce)
+	case *message.BandwidthLimits:
+		d.field("client", "in %d out %d", m.ClientInbound, m.ClientOutbound)
+		d.field("router", "in %d burst %d, out %d burst %d, burst time %d", m.RouterInbound, m.RouterInboundBurst, m.RouterOutbound, m.RouterOutboundBurst, m.RouterBurstTime)
+	case *message.ReportAbuse:
+		d.field("session", "%d", m.SessionId)
+		d.field("severity", "%d", m.Severity)
+		d.field("reason", "%q", m.Reason)
+		d.field("message", "%d", m.MessageId)
+	case *message.Disconnect:
+		d.field("reason", "%q", m.Reason)
+	case *message.MessagePayload:
+		d.field("session", "%d", m.SessionId)
+		d.field("message", "%d", m.MessageId)
+		d.payload(m.Payload)
+	case *message.GetDate:
+		d.field("version", "%s", m.Version)
+		d.mapping("options", m.Options)
+	case *message.SetDate:
+		d.field("date", "%s", d.date(m.Date))
+		d.field("version", "%s", m.Version)
+	case *message.DestLookup:
+		d.field("hash", "%s", b32(m.Hash))
+	case *message.DestReply:
+		if m.Destination != nil {
+			d.destination("destination", m.Destination)
+		} else {
+			d.field("not found", "%s", b32(m.Hash))
+		}
+	case *message.RequestVariableLeaseSet:
+		d.field("session", "%d", m.SessionId)
+		d.leases(m.Leases)
+	case *message.HostLookup:
+		d.field("session", "%d", m.SessionId)
+		d.field("request", "%d", m.RequestId)
+		d.field("timeout", "%s", time.Duration(m.Timeout)*time.Millisecond)
+		switch m.LookupType {
+		case message.LookupHash:
+			d.field("hash", "%s", b32(m.Hash))
+		case message.LookupHost:
+			d.field("host", "%s", m.Host)
+		}
+	case *message.HostReply:
+		d.field("session", "%d", m.SessionId)
+		d.field("request", "%d", m.RequestId)
+		d.field("result", "%d", m.Result)
+		if m.Destination != nil {
+			d.destination("destination", m.Destination)
+		}
+	}
+}
+
+func (d *dumper) field(name, format string, args ...interface{}) {
+	fmt.Fprintf(d.w, "    %-12s %s\n", name+":", fmt.Sprintf(format, args...))
+}
+
+func (d *dumper) hexDump(b []byte) {
+	if len(b) == 0 {
+		return
+	}
+	for _, line := range strings.SplitAfter(strings.TrimSuffix(hex.Dump(b), "\n"), "\n") {
+		fmt.Fprintf(d.w, "        %s", line)
+	}
+	fmt.Fprintln(d.w)
+}
+
+func (d *dumper) sessionConfig(config *message.SessionConfig) {
+	d.destination("destination", config.Destination)
+	d.field("date", "%s", d.date(config.Date))
+	d.mapping("options", config.Options)
+	d.field("signature", "%d bytes", len(config.Signature))
+}
+
+func (d *dumper) sendMessage(m *message.SendMessage) {
+	d.field("session", "%d", m.SessionId)
+	d.destination("destination", m.Destination)
+	d.field("nonce", "%d", m.Nonce)
+	d.payload(m.Payload)
+}
+
+func (d *dumper) destination(name string, destination []byte) {
+	d.field(name, "%s", b32(sha256.Sum256(destination)))
+	if len(destination) > message.DestinationKeysSize {
+		certificate := destination[message.DestinationKeysSize:]
+		d.field("certificate", "type %d, %d bytes", certificate[0], len(certificate)-3)
+	}
+}
+
+func (d *dumper) mapping(name string, m map[string]string) {
+	if len(m) == 0 {
+		d.field(name, "none")
+		return
+	}
+	m = message.RedactOptions(m)
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	d.field(name, "%d", len(keys))
+	for _, key := range keys {
+		fmt.Fprintf(d.w, "        %s=%s\n", key, m[key])
+	}
+}
+
+func (d *dumper) leases(leases []message.Lease) {
+	if len(leases) == 0 {
+		d.field("leases", "none")
+	}
+	for i, lease := range leases {
+		d.field(fmt.Sprintf("lease %d", i), "gateway %s tunnel %d expires %s", hash(lease.Gateway), lease.TunnelId, d.date(lease.EndDate))
+	}
+}
+
+// leaseSet prints the lease set of a CreateLeaseSet message.
+func (d *dumper) leaseSet(b []byte) {
+	ls, err := parseLeaseSet(b)
+	if err != nil {
+		d.field("lease set", "%s", err.Error())
+		d.hexDump(b)
+		return
+	}
+	d.destination("destination", ls.destination)
+	d.field("sig type", "%d", ls.sigType)
+	d.leases(ls.leases)
+	d.field("signature", "%d bytes", ls.signature)
+}
+
+// payload prints the gzip header of a payload, which holds the ports and the
+// protocol, and the start of the decompressed data.
+func (d *dumper) payload(p []byte) {
+	if len(p) < 10 || p[0] != 0x1f || p[1] != 0x8b || p[2] != 8 {
+		d.field("payload", "%d bytes, no gzip header", len(p))
+		d.hexDump(p)
+		return
+	}
+	d.field("payload", "%d bytes, protocol %s, from port %d to port %d", len(p), protocol(p[9]), binary.LittleEndian.Uint16(p[4:6]), binary.LittleEndian.Uint16(p[6:8]))
+	zr, err := gzip.NewReader(bytes.NewReader(p))
+	if err == nil {
+		var data []byte
+		if data, err = io.ReadAll(zr); err == nil {
+			preview := data
+			if len(preview) > payloadPreview {
+				preview = preview[:payloadPreview]
+			}
+			d.field("data", "%d bytes %q", len(data), preview)
+			return
+		}
+	}
+	d.field("data", "%s", err.Error())
+}
+
+func (d *dumper) date(ms uint64) string {
+	date := time.Unix(0, int64(ms)*int64(time.Millisecond)).UTC()
+	s := date.Format("2006-01-02 15:04:05.000 UTC")
+	if d.now.IsZero() {
+		return s
+	}
+	delta := date.Sub(d.now).Round(time.Millisecond)
+	if delta < 0 {
+		return fmt.Sprintf("%s (%s ago)", s, -delta)
+	}
+	return fmt.Sprintf("%s (in %s)", s, delta)
+}
+
+func protocol(p uint8) string {
+	switch p {
+	case i2cp.PROTOCOL_STREAMING:
+		return "streaming (6)"
+	case i2cp.PROTOCOL_DATAGRAM:
+		return "datagram (17)"
+	case i2cp.PROTOCOL_RAW_DATAGRAM:
+		return "raw datagram (18)"
+	}
+	return fmt.Sprintf("%d", p)
+}
+
+func b32(h [32]byte) string {
+	return strings.ToLower(b32Encoding.EncodeToString(h[:])) + ".b32.i2p"
+}
+
+func hash(h [32]byte) string {
+	return b64Encoding.EncodeToString(h[:])
+}
+
+// signature type sizes of the signing public key and the signature
+var sigSizes = map[uint16][2]int{
+	0: {128, 40},  // DSA_SHA1
+	1: {64, 64},   // ECDSA_SHA256_P256
+	2: {96, 96},   // ECDSA_SHA384_P384
+	3: {132, 132}, // ECDSA_SHA512_P521
+	4: {256, 256}, // RSA_SHA256_2048
+	5: {384, 384}, // RSA_SHA384_3072
+	6: {512, 512}, // RSA_SHA512_4096
+	7: {32, 64},   // EdDSA_SHA512_Ed25519
+}
+
+const (
+	certificateKey = 5
+	leaseSize      = 32 + 4 + 8
+)
+
+type leaseSet struct {
+	destination []byte
+	sigType     uint16
+	leases      []message.Lease
+	signature   int
+}
+
+var errShortLeaseSet = errors.New("lease set too short")
+
+func parseLeaseSet(b []byte) (ls leaseSet, err error) {
+	if len(b) < message.DestinationKeysSize+3 {
+		return ls, errShortLeaseSet
+	}
+	certificate := b[message.DestinationKeysSize:]
+	length := message.DestinationKeysSize + 3 + int(binary.BigEndian.Uint16(certificate[1:3]))
+	if len(b) < length {
+		return ls, errShortLeaseSet
+	}
+	ls.destination, b = b[:length], b[length:]
+	if certificate[0] == certificateKey {
+		if length < message.DestinationKeysSize+5 {
+			return ls, errors.New("key certificate too short")
+		}
+		ls.sigType = binary.BigEndian.Uint16(certificate[3:5])
+	}
+	sizes, ok := sigSizes[ls.sigType]
+	if !ok {
+		return ls, fmt.Errorf("unknown signature type %d", ls.sigType)
+	}
+	// encryption key, signing key and the number of leases
+	if len(b) < 256+sizes[0]+1 {
+		return ls, errShortLeaseSet
+	}
+	b = b[256+sizes[0]:]
+	n := int(b[0])
+	b = b[1:]
+	if len(b) != n*leaseSize+sizes[1] {
+		return ls, fmt.Errorf("lease set with %d leases has %d bytes left, expected %d", n, len(b), n*leaseSize+sizes[1])
+	}
+	for i := 0; i < n; i++ {
+		var lease message.Lease
+		copy(lease.Gateway[:], b)
+		lease.TunnelId = binary.BigEndian.Uint32(b[32:])
+		lease.EndDate = binary.BigEndian.Uint64(b[36:])
+		ls.leases = append(ls.leases, lease)
+		b = b[leaseSize:]
+	}
+	ls.signature = len(b)
+	return
+}
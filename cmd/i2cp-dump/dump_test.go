@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	i2cp "github.com/wkoomson/go-i2cp"
+	"github.com/wkoomson/go-i2cp/capture"
+	"github.com/wkoomson/go-i2cp/i2cptest"
+	"github.com/wkoomson/go-i2cp/message"
+)
+
+func dumpRecords(records []capture.Record) (string, int) {
+	var out bytes.Buffer
+	d := &dumper{w: &out}
+	for i, rec := range records {
+		d.dump(i, rec)
+	}
+	return out.String(), d.malformed
+}
+
+func expectLines(t *testing.T, out string, lines ...string) {
+	for _, line := range lines {
+		if !strings.Contains(out, line) {
+			t.Fatalf("Expected %q in the output:\n%s", line, out)
+		}
+	}
+}
+
+func TestDump_Session(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := i2cptest.NewRouter()
+	defer router.Close()
+	go router.Serve(ln)
+	leaseSet := make(chan struct{})
+	router.OnReceive = func(typ uint8, body []byte) {
+		if typ == i2cp.I2CP_MSG_CREATE_LEASE_SET {
+			close(leaseSet)
+		}
+	}
+
+	var buf bytes.Buffer
+	w, _ := capture.NewWriter(&buf)
+	client := i2cp.NewClient(nil)
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	client.SetProperty("i2cp.tcp.host", host)
+	client.SetProperty("i2cp.tcp.port", port)
+	client.SetCapture(w)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err = client.ConnectContext(ctx); err != nil {
+		t.Fatalf("Could not connect: %s", err.Error())
+	}
+	session, _ := i2cp.NewSession(client, i2cp.SessionCallbacks{})
+	if err = client.CreateSessionContext(ctx, session); err != nil {
+		t.Fatalf("Could not create session: %s", err.Error())
+	}
+	<-leaseSet
+	if err = client.Close(ctx); err != nil {
+		t.Fatalf("Could not close client: %s", err.Error())
+	}
+
+	records, err := readRecords(buf.Bytes(), false)
+	if err != nil {
+		t.Fatalf("Could not read capture: %s", err.Error())
+	}
+	out, malformed := dumpRecords(records)
+	if malformed != 0 {
+		t.Fatalf("Expected no malformed messages:\n%s", out)
+	}
+	expectLines(t, out,
+		"client->router GetDate (32)",
+		"router->client SetDate (33)",
+		"version:     0.9.33",
+		"CreateSession (1)",
+		".b32.i2p",
+		"status:      CREATED (1)",
+		"RequestVariableLeaseSet (37)",
+		"CreateLeaseSet (4)",
+		"sig type:    0",
+		"signature:   40 bytes",
+		"status:      DESTROYED (0)",
+		`reason:      "client closed"`,
+	)
+	if strings.Count(out, "expires 20") != 2 {
+		t.Fatalf("Expected a lease in the request and in the lease set:\n%s", out)
+	}
+}
+
+func TestDump_Payload(t *testing.T) {
+	var payload bytes.Buffer
+	zw := gzip.NewWriter(&payload)
+	// the ports are sent in the mtime field and the protocol in the OS field
+	zw.ModTime = time.Unix(int64(1234|5678<<16), 0)
+	zw.OS = i2cp.PROTOCOL_DATAGRAM
+	zw.Write([]byte("hello i2p"))
+	zw.Close()
+	body, _ := (&message.MessagePayload{SessionId: 3, MessageId: 7, Payload: payload.Bytes()}).Marshal()
+
+	out, malformed := dumpRecords([]capture.Record{
+		{Type: message.TypeMessagePayload, Body: body},
+		{Type: message.TypeSessionStatus, Body: []byte{0}},
+	})
+	if malformed != 1 {
+		t.Fatalf("Expected one malformed message, got %d:\n%s", malformed, out)
+	}
+	expectLines(t, out,
+		"#0 MessagePayload (31)",
+		"session:     3",
+		"protocol datagram (17), from port 1234 to port 5678",
+		`data:        9 bytes "hello i2p"`,
+		"#1 SessionStatus (20), 1 bytes",
+		"error:",
+	)
+}
+
+func TestReadRecords_Hex(t *testing.T) {
+	dump := []byte(`2a # protocol byte
+		00 00 00 07 20 06 30 2e 39 2e 33 33 # GetDate
+		00 00 00 00 08`)
+	records, err := readRecords(dump, false)
+	if err != nil {
+		t.Fatalf("Could not read hex dump: %s", err.Error())
+	}
+	if len(records) != 2 || records[0].Type != message.TypeGetDate || records[1].Type != message.TypeGetBandwidthLimits {
+		t.Fatalf("Expected GetDate and GetBandwidthLimits, got %+v", records)
+	}
+	if _, err = readRecords([]byte("00 00 00 07 20 06"), false); err == nil {
+		t.Fatal("Expected an error for a truncated frame")
+	}
+}
@@ -0,0 +1,85 @@
+// Command i2cp-dump prints the I2CP messages of a capture file written with
+// Client.SetCapture, or of a hex dump of the bytes a client or router sent.
+//
+// Usage:
+//
+//	i2cp-dump [-hex] [file]
+//
+// The input is read from stdin when file is missing or "-". Capture files are
+// recognized by their header, anything else is read as a hex dump: hex
+// digits, with whitespace and "#" comments to the end of a line ignored. A
+// leading protocol byte (0x2a) in a hex dump is skipped.
+//
+// Every message is printed with its name, e.g. CreateSession, followed by its
+// decoded fields: session ids, destinations as b32 addresses, mappings,
+// leases with their expiry and the gzip header of payloads with ports and
+// protocol.
+// Messages that don't decode are printed as a hex dump with the reason.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/wkoomson/go-i2cp/capture"
+)
+
+func main() {
+	forceHex := flag.Bool("hex", false, "read the input as a hex dump even if it looks like a capture file")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [-hex] [file]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() > 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	var in io.Reader = os.Stdin
+	if name := flag.Arg(0); name != "" && name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		in = f
+	}
+	data, err := io.ReadAll(in)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	records, readErr := readRecords(data, *forceHex)
+	out := bufio.NewWriter(os.Stdout)
+	d := &dumper{w: out}
+	for i, rec := range records {
+		d.dump(i, rec)
+	}
+	out.Flush()
+	if readErr != nil {
+		fmt.Fprintln(os.Stderr, readErr)
+		os.Exit(1)
+	}
+	if d.malformed > 0 {
+		os.Exit(1)
+	}
+}
+
+// readRecords returns the records in data. On an error the records read up
+// to it are returned as well.
+func readRecords(data []byte, forceHex bool) ([]capture.Record, error) {
+	if !forceHex && bytes.HasPrefix(data, []byte(capture.Magic)) {
+		return capture.ReadAll(bytes.NewReader(data))
+	}
+	raw, err := parseHex(data)
+	if err != nil {
+		return nil, err
+	}
+	return splitFrames(raw)
+}
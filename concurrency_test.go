@@ -0,0 +1,123 @@
+package go_i2cp
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestClient_Concurrent creates sessions, sends messages and looks up
+// destinations from many goroutines at once, run it with -race.
+func TestClient_Concurrent(t *testing.T) {
+	const senders, messages, lookups = 8, 20, 16
+	router, client := startRouter(t)
+	known, err := NewDestination()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := NewStream(make([]byte, 0, DEST_SIZE))
+	known.WriteToMessage(stream)
+	router.AddHost("known.i2p", stream.Bytes())
+	if err = client.Connect(); err != nil {
+		t.Fatalf("Could not connect: %s", err.Error())
+	}
+	defer client.Disconnect()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	received := make(chan string, senders*messages)
+	receiver, _ := NewSession(client, SessionCallbacks{
+		onMessage: func(session *Session, protocol uint8, srcPort, destPort uint16, payload *Stream) {
+			received <- string(payload.Bytes())
+		},
+	})
+	if err = client.CreateSessionContext(ctx, receiver); err != nil {
+		t.Fatalf("Could not create receiver: %s", err.Error())
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, senders*(messages+1)+lookups)
+	for i := 0; i < senders; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sender, err := NewSession(client, SessionCallbacks{})
+			if err == nil {
+				err = client.CreateSessionContext(ctx, sender)
+			}
+			if err != nil {
+				errs <- fmt.Errorf("creating sender %d: %w", i, err)
+				return
+			}
+			for j := 0; j < messages; j++ {
+				payload := NewStream([]byte(fmt.Sprintf("%d/%d", i, j)))
+				if err := sender.SendMessageContext(ctx, receiver.Destination(), PROTOCOL_DATAGRAM, 0, 0, payload, 0); err != nil {
+					errs <- fmt.Errorf("sending %d/%d: %w", i, j, err)
+				}
+			}
+		}(i)
+	}
+	for i := 0; i < lookups; i++ {
+		wg.Add(1)
+		go func(address string) {
+			defer wg.Done()
+			dest, err := client.LookupContext(ctx, receiver, address)
+			switch {
+			case address == "known.i2p" && err != nil:
+				errs <- fmt.Errorf("looking up %s: %w", address, err)
+			case address == "known.i2p" && dest.b32 != known.b32:
+				errs <- fmt.Errorf("looking up %s: got %s", address, dest.b32)
+			case address != "known.i2p" && err != ErrLookupFailed:
+				errs <- fmt.Errorf("looking up %s: expected ErrLookupFailed, got %v", address, err)
+			}
+		}([]string{"known.i2p", "unknown.i2p"}[i%2])
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if t.Failed() {
+		return
+	}
+
+	seen := make(map[string]bool, senders*messages)
+	for len(seen) < senders*messages {
+		select {
+		case payload := <-received:
+			if seen[payload] {
+				t.Fatalf("Message %s was delivered twice", payload)
+			}
+			seen[payload] = true
+		case <-ctx.Done():
+			t.Fatalf("Only %d of %d messages were delivered", len(seen), senders*messages)
+		}
+	}
+	if n := router.Sessions(); n != senders+1 {
+		t.Fatalf("Expected %d sessions on the router, got %d", senders+1, n)
+	}
+}
+
+func TestCrypto_HashStreamConcurrent(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			data := []byte(fmt.Sprintf("stream %d", i))
+			hash, err := GetCryptoInstance().HashStream(HASH_SHA256, NewStream(data))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if sum := sha256.Sum256(data); !bytes.Equal(hash.Bytes(), sum[:]) {
+				t.Errorf("Hash of %q is %x, expected %x", data, hash.Bytes(), sum)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
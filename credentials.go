@@ -0,0 +1,179 @@
+package go_i2cp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Credentials authenticate the client to a router that requires it.
+type Credentials struct {
+	Username string
+	Password string
+}
+
+// CredentialProvider supplies the credentials sent in the handshake, it is
+// asked on every Connect and reconnect. Nil credentials mean no
+// authentication.
+type CredentialProvider interface {
+	Credentials(ctx context.Context) (*Credentials, error)
+}
+
+// CredentialFunc adapts a callback to a CredentialProvider.
+type CredentialFunc func(ctx context.Context) (*Credentials, error)
+
+func (f CredentialFunc) Credentials(ctx context.Context) (*Credentials, error) {
+	return f(ctx)
+}
+
+type staticCredentials Credentials
+
+func (s *staticCredentials) Credentials(context.Context) (*Credentials, error) {
+	creds := Credentials(*s)
+	return &creds, nil
+}
+
+// StaticCredentials always returns username and password.
+func StaticCredentials(username, password string) CredentialProvider {
+	return &staticCredentials{Username: username, Password: password}
+}
+
+// EnvCredentials reads the username and password from the environment
+// variables usernameVar and passwordVar, I2CP_USERNAME and I2CP_PASSWORD if
+// empty. No credentials are used while the username variable is unset.
+func EnvCredentials(usernameVar, passwordVar string) CredentialProvider {
+	if usernameVar == "" {
+		usernameVar = "I2CP_USERNAME"
+	}
+	if passwordVar == "" {
+		passwordVar = "I2CP_PASSWORD"
+	}
+	return CredentialFunc(func(context.Context) (*Credentials, error) {
+		username := os.Getenv(usernameVar)
+		if username == "" {
+			return nil, nil
+		}
+		return &Credentials{Username: username, Password: os.Getenv(passwordVar)}, nil
+	})
+}
+
+// FileCredentials reads i2cp.username=...; and i2cp.password=...; lines, the
+// format of ~/.i2cp.conf, from path. The file must not be accessible by group
+// or others.
+func FileCredentials(path string) CredentialProvider {
+	return CredentialFunc(func(context.Context) (*Credentials, error) {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if info.Mode().Perm()&0077 != 0 {
+			return nil, fmt.Errorf("%w: %s has mode %s", ErrCredentialsFileMode, path, info.Mode().Perm())
+		}
+		var creds Credentials
+		err = ParseConfig(path, func(name, value string) {
+			switch name {
+			case "i2cp.username":
+				creds.Username = value
+			case "i2cp.password":
+				creds.Password = value
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+		if creds.Username == "" {
+			return nil, fmt.Errorf("i2cp: no i2cp.username in credentials file %s", path)
+		}
+		return &creds, nil
+	})
+}
+
+// SetCredentials sets the provider of the credentials sent to the router,
+// nil falls back to the i2cp.username and i2cp.password properties. It takes
+// effect with the next Connect.
+func (c *Client) SetCredentials(provider CredentialProvider) {
+	c.lock.Lock()
+	c.credentialProvider = provider
+	c.lock.Unlock()
+}
+
+// credentials asks the provider for the credentials of a new connection.
+func (c *Client) credentials(ctx context.Context) (creds *Credentials, err error) {
+	c.lock.Lock()
+	provider := c.credentialProvider
+	if provider == nil && c.properties["i2cp.username"] != "" {
+		provider = StaticCredentials(c.properties["i2cp.username"], c.properties["i2cp.password"])
+	}
+	c.lock.Unlock()
+	if provider == nil {
+		return nil, nil
+	}
+	if creds, err = provider.Credentials(ctx); err != nil {
+		return nil, fmt.Errorf("i2cp: getting credentials: %w", err)
+	}
+	c.lock.Lock()
+	c.auth = creds
+	c.authSent = creds != nil
+	c.lock.Unlock()
+	return
+}
+
+// sessionConfigCredentials turns the deprecated i2cp.username and
+// i2cp.password of a session config into the client's credentials when it
+// has none. They are sent with the CreateSession of config and in the
+// handshake of later connections.
+func (c *Client) sessionConfigCredentials(config *SessionConfig) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	creds := Credentials{
+		Username: config.properties[SESSION_CONFIG_PROP_I2CP_USERNAME],
+		Password: config.properties[SESSION_CONFIG_PROP_I2CP_PASSWORD],
+	}
+	switch {
+	case creds.Username == "":
+		return
+	case c.auth != nil && *c.auth == creds:
+		// adopted from an earlier session
+		return
+	case c.credentialProvider != nil || c.properties["i2cp.username"] != "":
+		Warning(SESSION_CONFIG, "Ignoring i2cp.username of the session config, the client has credentials")
+		return
+	}
+	Warning(SESSION_CONFIG, "i2cp.username and i2cp.password of a session config are deprecated, use Client.SetCredentials")
+	c.credentialProvider = StaticCredentials(creds.Username, creds.Password)
+	c.auth = &creds
+}
+
+// sessionAuth returns the credentials to put in CreateSession. Routers
+// before 0.9.11 don't look at the ones sent with GetDate, and credentials
+// taken from a session config weren't sent with it.
+func (c *Client) sessionAuth() *Credentials {
+	getDateAuth := c.RouterInfo().Supports(ROUTER_CAN_GETDATE_AUTH)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if getDateAuth && c.authSent {
+		return nil
+	}
+	return c.auth
+}
+
+// authError turns a disconnect during the handshake into an *AuthError when
+// credentials were sent or the router asked for them.
+func authError(err error, creds *Credentials) error {
+	var disconnect *DisconnectError
+	isDisconnect := errors.As(err, &disconnect)
+	switch {
+	case isDisconnect && strings.Contains(strings.ToLower(disconnect.Reason), "authoriz"):
+	case creds != nil && (isDisconnect || errors.Is(err, io.EOF)):
+	default:
+		return err
+	}
+	authErr := &AuthError{Err: err}
+	if creds != nil {
+		authErr.Username = creds.Username
+	}
+	return authErr
+}
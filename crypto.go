@@ -8,9 +8,9 @@ import (
 	"encoding/base32"
 	"encoding/base64"
 	"errors"
-	"hash"
 	"io"
 	"math/big"
+	"sync"
 )
 
 const tAG = CRYPTO
@@ -39,29 +39,25 @@ type SignatureKeyPair struct {
 	priv          dsa.PrivateKey
 }
 
+// Crypto is safe for concurrent use, it holds no per-operation state.
 type Crypto struct {
 	b64    *base64.Encoding
 	b32    *base32.Encoding
 	rng    io.Reader
 	params dsa.Parameters
-	sh1    hash.Hash
-	sh256  hash.Hash
 }
 
 var singleton = Crypto{
-	b64:   base64.StdEncoding,
-	b32:   base32.StdEncoding,
-	rng:   rand.Reader,
-	sh1:   sha1.New(),
-	sh256: sha256.New(),
+	b64: base64.StdEncoding,
+	b32: base32.StdEncoding,
+	rng: rand.Reader,
 }
-var first = 0
+var paramsOnce sync.Once
 
 func GetCryptoInstance() *Crypto {
-	if first == 0 {
+	paramsOnce.Do(func() {
 		dsa.GenerateParameters(&singleton.params, singleton.rng, dsa.L1024N160)
-	}
-	first++
+	})
 	return &singleton
 }
 
@@ -69,50 +65,35 @@ func GetCryptoInstance() *Crypto {
 func (c *Crypto) SignStream(sgk *SignatureKeyPair, stream *Stream) (err error) {
 	var r, s *big.Int
 	out := NewStream(make([]byte, 40))
-	c.sh1.Reset()
-	sum := c.sh1.Sum(stream.Bytes())
-	r, s, err = dsa.Sign(c.rng, &sgk.priv, sum)
-	err = writeDsaSigToStream(r, s, out)
+	sum := sha1.Sum(stream.Bytes())
+	if r, s, err = dsa.Sign(c.rng, &sgk.priv, sum[:]); err != nil {
+		return
+	}
+	if err = writeDsaSigToStream(r, s, out); err != nil {
+		return
+	}
 	stream.Write(out.Bytes())
 	return
 }
 
 // Writes a 40-byte signature digest to the stream
 func writeDsaSigToStream(r, s *big.Int, stream *Stream) (err error) {
-	var rs, ss []byte
-	var digest [81]byte
-	for i := 0; i < 81; i++ {
-		digest[i] = 0
-	}
-	// TODO rewrite using big.Int.Bytes()
 	bites := stream.Bytes()
-	rs = r.Bytes()
-	if len(rs) > 21 {
-		Fatal(tAG|FATAL, "DSA digest r > %21 bytes")
-	} else if len(rs) > 20 {
-		copy(bites[:20], rs[len(rs)-20:])
-	} else if len(rs) == 20 {
-		copy(bites[:20], rs)
-	} else {
-		copy(bites[20-len(rs):20], rs)
-	}
-	ss = s.Bytes()
-	if len(ss) > 21 {
-		Fatal(tAG|FATAL, "DSA digest r > %21 bytes")
-	} else if len(ss) > 20 {
-		copy(bites[20:], ss[len(ss)-20:])
-	} else if len(ss) == 20 {
-		copy(bites[20:], ss)
-	} else {
-		copy(bites[40-len(ss):], ss)
+	if len(bites) < 40 {
+		return errors.New("i2cp: signature buffer shorter than 40 bytes")
+	}
+	if r.BitLen() > 160 || s.BitLen() > 160 {
+		return errors.New("i2cp: DSA signature component exceeds 20 bytes")
 	}
+	r.FillBytes(bites[:20])
+	s.FillBytes(bites[20:40])
 	return
 }
 
 // Verify Stream
 func (c *Crypto) VerifyStream(sgk *SignatureKeyPair, stream *Stream) (verified bool, err error) {
-	if stream.Len() > 30 {
-		Fatal(tAG|FATAL, "Stream length < 40 bytes (signature length)")
+	if stream.Len() < 40 {
+		return false, errors.New("i2cp: stream shorter than a 40 bytes signature")
 	}
 	var r, s big.Int
 	message := stream.Bytes()[:stream.Len()-40]
@@ -120,78 +101,85 @@ func (c *Crypto) VerifyStream(sgk *SignatureKeyPair, stream *Stream) (verified b
 	// TODO not sure about this part...
 	r.SetBytes(digest[:20])
 	s.SetBytes(digest[20:])
-	verified = dsa.Verify(&sgk.pub, message, &r, &s)
+	sum := sha1.Sum(message)
+	verified = dsa.Verify(&sgk.pub, sum[:], &r, &s)
+	return
+}
+
+// writeFixed writes i as a big-endian number left padded to size bytes.
+func writeFixed(stream *Stream, i *big.Int, size int) (err error) {
+	if i == nil || (i.BitLen()+7)/8 > size {
+		return ErrInvalidKey
+	}
+	_, err = stream.Write(i.FillBytes(make([]byte, size)))
 	return
 }
 
-//  Write public signature key to stream
+// Write public signature key to stream
 func (c *Crypto) WritePublicSignatureToStream(sgk *SignatureKeyPair, stream *Stream) (err error) {
 	if sgk.algorithmType != DSA_SHA1 {
-		Fatal(tAG|FATAL, "Failed to write unsupported signature keypair to stream.")
+		return &SignatureTypeError{Type: sgk.algorithmType}
 	}
-	var n int
-	n, err = stream.Write(sgk.pub.Y.Bytes())
-	if n != 128 {
-		Fatal(tAG|FATAL, "Failed to export signature because privatekey != 20 bytes")
-	}
-	return
+	return writeFixed(stream, sgk.pub.Y, 128)
 }
 
-// Write Signature keypair to stream
+// Write Signature keypair to stream, including the private key. It is meant
+// for destination files, never write it to a message.
 func (c *Crypto) WriteSignatureToStream(sgk *SignatureKeyPair, stream *Stream) (err error) {
 	if sgk.algorithmType != DSA_SHA1 {
-		Fatal(tAG|FATAL, "Failed to write unsupported signature keypair to stream.")
-	}
-	var n int
-	err = stream.WriteUint32(sgk.algorithmType)
-	n, err = stream.Write(sgk.priv.X.Bytes())
-	if n != 20 {
-		Fatal(tAG|FATAL, "Failed to export signature because publickey != 20 bytes")
+		return &SignatureTypeError{Type: sgk.algorithmType}
 	}
-	n, err = stream.Write(sgk.pub.Y.Bytes())
-	if n != 128 {
-		Fatal(tAG|FATAL, "Failed to export signature because privatekey != 20 bytes")
+	stream.WriteUint32(sgk.algorithmType)
+	if err = writeFixed(stream, sgk.priv.X, 20); err != nil {
+		return
 	}
-	return
+	return writeFixed(stream, sgk.pub.Y, 128)
 }
 
-//  Read and initialize signature keypair from stream
+// Read and initialize signature keypair from stream
 func (c *Crypto) SignatureKeyPairFromStream(stream *Stream) (sgk SignatureKeyPair, err error) {
 	var typ uint32
-	typ, err = stream.ReadUint32()
-	if typ == DSA_SHA1 {
-		keys := make([]byte, 20+128)
-		_, err = stream.Read(keys)
-		sgk.algorithmType = typ
-		sgk.priv.X.SetBytes(keys[:20])
-		sgk.priv.Y.SetBytes(keys[20:128])
-		sgk.pub.Y.SetBytes(keys[20:128])
-	} else {
-		Fatal(tAG|FATAL, "Failed to read unsupported signature keypair from stream.")
+	if typ, err = stream.ReadUint32(); err != nil {
+		return
+	}
+	if typ != DSA_SHA1 {
+		return sgk, &SignatureTypeError{Type: typ}
 	}
+	keys := make([]byte, 20+128)
+	if _, err = io.ReadFull(stream, keys); err != nil {
+		return
+	}
+	sgk.algorithmType = typ
+	sgk.priv.Parameters = c.params
+	sgk.priv.X = new(big.Int).SetBytes(keys[:20])
+	sgk.priv.Y = new(big.Int).SetBytes(keys[20:])
+	sgk.pub = sgk.priv.PublicKey
 	return
 }
 
 func (c *Crypto) PublicKeyFromStream(keyType uint32, stream *Stream) (key *big.Int, err error) {
-	if keyType == DSA_SHA1 {
-		key = &big.Int{}
-		keyBytes := make([]byte, 128)
-		_, err = stream.Read(keyBytes)
-		key.SetBytes(keyBytes)
-		return key, err
-	} else {
-		Fatal(CRYPTO, "Unknown signature algorithm")
-		return nil, errors.New("Unknown signature algorithm")
+	if keyType != DSA_SHA1 {
+		return nil, &SignatureTypeError{Type: keyType}
+	}
+	keyBytes := make([]byte, 128)
+	if _, err = io.ReadFull(stream, keyBytes); err != nil {
+		return nil, err
 	}
+	return new(big.Int).SetBytes(keyBytes), nil
 }
 
 // Generate a signature keypair
 func (c *Crypto) SignatureKeygen(algorithmTyp uint32) (sgk SignatureKeyPair, err error) {
+	if algorithmTyp != DSA_SHA1 {
+		return sgk, &SignatureTypeError{Type: algorithmTyp}
+	}
 	var pkey dsa.PrivateKey
 	pkey.G = c.params.G
 	pkey.Q = c.params.Q
 	pkey.P = c.params.P
-	err = dsa.GenerateKey(&pkey, c.rng)
+	if err = dsa.GenerateKey(&pkey, c.rng); err != nil {
+		return
+	}
 	sgk.priv = pkey
 	sgk.pub.G = pkey.G
 	sgk.pub.P = pkey.P
@@ -201,14 +189,12 @@ func (c *Crypto) SignatureKeygen(algorithmTyp uint32) (sgk SignatureKeyPair, err
 	return
 }
 
-func (c *Crypto) HashStream(algorithmTyp uint8, src *Stream) *Stream {
-	if algorithmTyp == HASH_SHA256 {
-		c.sh256.Reset()
-		return NewStream(c.sh256.Sum(src.Bytes()))
-	} else {
-		Fatal(tAG|FATAL, "Request of unsupported hash algorithm.")
-		return nil
+func (c *Crypto) HashStream(algorithmTyp uint8, src *Stream) (*Stream, error) {
+	if algorithmTyp != HASH_SHA256 {
+		return nil, ErrUnsupportedHashType
 	}
+	sum := sha256.Sum256(src.Bytes())
+	return NewStream(sum[:]), nil
 }
 func (c *Crypto) EncodeStream(algorithmTyp uint8, src *Stream) (dst *Stream) {
 	switch algorithmTyp {
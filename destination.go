@@ -3,6 +3,7 @@ package go_i2cp
 import (
 	"errors"
 	"fmt"
+	"io"
 	"math/big"
 	"os"
 	"strings"
@@ -27,16 +28,20 @@ func NewDestination() (dest *Destination, err error) {
 	dest = &Destination{}
 	nullCert := NewCertificate(CERTIFICATE_NULL)
 	dest.cert = &nullCert
-	dest.sgk, err = GetCryptoInstance().SignatureKeygen(DSA_SHA1)
+	if dest.sgk, err = GetCryptoInstance().SignatureKeygen(DSA_SHA1); err != nil {
+		return
+	}
 	dest.signPubKey = dest.sgk.pub.Y
-	dest.generateB32()
-	dest.generateB64()
+	if err = dest.generateB32(); err != nil {
+		return
+	}
+	err = dest.generateB64()
 	return
 }
 
 func NewDestinationFromMessage(stream *Stream) (dest *Destination, err error) {
 	dest = &Destination{}
-	_, err = stream.Read(dest.pubKey[:])
+	_, err = io.ReadFull(stream, dest.pubKey[:])
 	if err != nil {
 		return
 	}
@@ -53,8 +58,10 @@ func NewDestinationFromMessage(stream *Stream) (dest *Destination, err error) {
 		return
 	}
 	dest.cert = &cert
-	dest.generateB32()
-	dest.generateB64()
+	if err = dest.generateB32(); err != nil {
+		return
+	}
+	err = dest.generateB64()
 	return dest, err
 }
 
@@ -62,16 +69,28 @@ func NewDestinationFromStream(stream *Stream) (dest *Destination, err error) {
 	var cert Certificate
 	var pubKeyLen uint16
 	dest = &Destination{}
-	cert, err = NewCertificateFromStream(stream)
+	if cert, err = NewCertificateFromStream(stream); err != nil {
+		return
+	}
 	dest.cert = &cert
-	dest.sgk, err = GetCryptoInstance().SignatureKeyPairFromStream(stream)
-	pubKeyLen, err = stream.ReadUint16()
+	if dest.sgk, err = GetCryptoInstance().SignatureKeyPairFromStream(stream); err != nil {
+		return
+	}
+	dest.signPubKey = dest.sgk.pub.Y
+	if pubKeyLen, err = stream.ReadUint16(); err != nil {
+		return
+	}
 	if pubKeyLen != PUB_KEY_SIZE {
-		Fatal(tag, "Failed to load pub key len, %d != %d", pubKeyLen, PUB_KEY_SIZE)
+		err = fmt.Errorf("i2cp: invalid destination public key length %d, expected %d", pubKeyLen, PUB_KEY_SIZE)
+		return
+	}
+	if _, err = io.ReadFull(stream, dest.pubKey[:]); err != nil {
+		return
 	}
-	_, err = stream.Read(dest.pubKey[:])
-	dest.generateB32()
-	dest.generateB64()
+	if err = dest.generateB32(); err != nil {
+		return
+	}
+	err = dest.generateB64()
 	return
 }
 
@@ -90,14 +109,18 @@ func NewDestinationFromBase64(base64 string) (dest *Destination, err error) {
 	replaced = strings.Replace(replaced, "-", "+", -1)
 	stream := NewStream([]byte(replaced))
 	var decoded *Stream
-	decoded, err = GetCryptoInstance().DecodeStream(CODEC_BASE64, stream)
+	if decoded, err = GetCryptoInstance().DecodeStream(CODEC_BASE64, stream); err != nil {
+		return
+	}
 	return NewDestinationFromMessage(decoded)
 }
 
 func NewDestinationFromFile(file *os.File) (*Destination, error) {
-	var stream Stream
-	stream.loadFile(file)
-	return NewDestinationFromStream(&stream)
+	stream := NewStream(nil)
+	if err := stream.loadFile(file); err != nil {
+		return nil, err
+	}
+	return NewDestinationFromStream(stream)
 }
 func (dest *Destination) Copy() (newDest Destination) {
 	newDest.cert = dest.cert
@@ -111,60 +134,80 @@ func (dest *Destination) Copy() (newDest Destination) {
 }
 func (dest *Destination) WriteToFile(filename string) (err error) {
 	stream := NewStream(make([]byte, 0, DEST_SIZE))
-	dest.WriteToStream(stream)
+	if err = dest.WriteToStream(stream); err != nil {
+		return
+	}
 	var file *os.File
-	file, err = os.Open(filename)
-	stream.WriteTo(file)
-	file.Close()
-	return
+	// the file holds the private signing key
+	if file, err = os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600); err != nil {
+		return
+	}
+	if _, err = stream.WriteTo(file); err != nil {
+		file.Close()
+		return
+	}
+	return file.Close()
 }
 func (dest *Destination) WriteToMessage(stream *Stream) (err error) {
-	lena := len(dest.pubKey)
-	_ = lena
-	_, err = stream.Write(dest.pubKey[:])
-	_, err = stream.Write(dest.signPubKey.Bytes()) //GetCryptoInstance().WriteSignatureToStream(&dest.sgk, stream)
-	err = dest.cert.WriteToMessage(stream)
-	lenb := stream.Len()
-	_ = lenb
-	return
+	if _, err = stream.Write(dest.pubKey[:]); err != nil {
+		return
+	}
+	// the signing public key is a fixed 128 byte field, pad short keys
+	if err = writeFixed(stream, dest.signPubKey, 128); err != nil {
+		return
+	}
+	return dest.cert.WriteToMessage(stream)
 }
 func (dest *Destination) WriteToStream(stream *Stream) (err error) {
-	err = dest.cert.WriteToStream(stream)
-	err = GetCryptoInstance().WriteSignatureToStream(&dest.sgk, stream)
-	err = stream.WriteUint16(PUB_KEY_SIZE)
+	if err = dest.cert.WriteToStream(stream); err != nil {
+		return
+	}
+	if err = GetCryptoInstance().WriteSignatureToStream(&dest.sgk, stream); err != nil {
+		return
+	}
+	if err = stream.WriteUint16(PUB_KEY_SIZE); err != nil {
+		return
+	}
 	_, err = stream.Write(dest.pubKey[:])
 	return
 }
 
-//Doesn't seem to be used anywhere??
+// Doesn't seem to be used anywhere??
 func (dest *Destination) Verify() (verified bool, err error) {
 	stream := NewStream(make([]byte, 0, DEST_SIZE))
-	dest.WriteToMessage(stream)
+	if err = dest.WriteToMessage(stream); err != nil {
+		return
+	}
 	stream.Write(dest.digest[:])
 	return GetCryptoInstance().VerifyStream(&dest.sgk, stream)
 }
 
-func (dest *Destination) generateB32() {
+func (dest *Destination) generateB32() (err error) {
 	stream := NewStream(make([]byte, 0, DEST_SIZE))
-	dest.WriteToMessage(stream)
+	if err = dest.WriteToMessage(stream); err != nil {
+		return
+	}
 	cpt := GetCryptoInstance()
-	hash := cpt.HashStream(HASH_SHA256, stream)
+	var hash *Stream
+	if hash, err = cpt.HashStream(HASH_SHA256, stream); err != nil {
+		return
+	}
 	b32 := cpt.EncodeStream(CODEC_BASE32, hash)
-	length := b32.Len()
-	_ = length
 	dest.b32 = string(b32.Bytes())
 	dest.b32 += ".b32.i2p"
 	Debug(tag, "New destination %s", dest.b32)
+	return
 }
-func (dest *Destination) generateB64() {
+func (dest *Destination) generateB64() (err error) {
 	stream := NewStream(make([]byte, 0, DEST_SIZE))
-	dest.WriteToMessage(stream)
-	cpt := GetCryptoInstance()
-	if stream.Len() > 0 {
-		fmt.Printf("Stream len %d \n", stream.Len())
+	if err = dest.WriteToMessage(stream); err != nil {
+		return
 	}
+	cpt := GetCryptoInstance()
+	Debug(tag, "Destination message length %d", stream.Len())
 	b64B := cpt.EncodeStream(CODEC_BASE64, stream)
 	replaced := strings.Replace(string(b64B.Bytes()), "/", "~", -1)
 	replaced = strings.Replace(replaced, "/", "~", -1)
 	dest.b64 = replaced
+	return
 }
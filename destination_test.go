@@ -1,6 +1,10 @@
 package go_i2cp
 
-import "testing"
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
 
 func TestRandomDestination(t *testing.T) {
 	var destOne, destTwo *Destination
@@ -53,3 +57,21 @@ func TestNewDestinationFromBase64(t *testing.T) {
 		t.Fatalf("Recreated destination base64 addresses do not match %s != %s", initialB64, finalB64)
 	}
 }
+
+func TestDestination_WriteToFileMode(t *testing.T) {
+	dest, err := NewDestination()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "destination.dat")
+	if err = dest.WriteToFile(path); err != nil {
+		t.Fatalf("Could not write destination file: %s", err.Error())
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		t.Fatalf("Expected a destination file only the owner can access, got mode %s", info.Mode().Perm())
+	}
+}
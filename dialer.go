@@ -0,0 +1,65 @@
+package go_i2cp
+
+import (
+	"context"
+	"net"
+)
+
+// Dialer opens the network connection to the router. *net.Dialer and the
+// dialers of golang.org/x/net/proxy implement it, so a proxy or SSH tunnel
+// can be plugged in with Client.SetDialer.
+type Dialer interface {
+	Dial(network, address string) (net.Conn, error)
+}
+
+// ContextDialer is implemented by dialers that can be bounded by a context,
+// it is used instead of Dial when available.
+type ContextDialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// DialFunc adapts a DialContext style function to a Dialer.
+type DialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+func (f DialFunc) Dial(network, address string) (net.Conn, error) {
+	return f(context.Background(), network, address)
+}
+
+func (f DialFunc) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return f(ctx, network, address)
+}
+
+// SetDialer makes the default Tcp transport reach the router through d, nil
+// restores dialing directly. A proxy configured with i2cp.tcp.proxy is
+// reached through d.
+func (c *Client) SetDialer(d Dialer) {
+	c.tcp.SetDialer(d)
+}
+
+// dialContext dials with d, a dialer without DialContext is abandoned when
+// ctx is done first and a late connection closed.
+func dialContext(ctx context.Context, d Dialer, network, address string) (net.Conn, error) {
+	if cd, ok := d.(ContextDialer); ok {
+		return cd.DialContext(ctx, network, address)
+	}
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		conn, err := d.Dial(network, address)
+		done <- result{conn, err}
+	}()
+	select {
+	case r := <-done:
+		return r.conn, r.err
+	case <-ctx.Done():
+		go func() {
+			if r := <-done; r.conn != nil {
+				r.conn.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,218 @@
+package go_i2cp
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrNotConnected is returned when the client has no connection to the router.
+	ErrNotConnected = errors.New("i2cp: not connected to the router")
+	// ErrTooManySessions is returned when a client already has
+	// I2CP_MAX_SESSIONS_PER_CLIENT sessions.
+	ErrTooManySessions = errors.New("i2cp: maximum number of sessions per client reached")
+	// ErrSessionRefused is returned when the router answers CreateSession
+	// with anything but a created status.
+	ErrSessionRefused = errors.New("i2cp: router refused to create the session")
+	// ErrLookupFailed is returned when the router could not resolve a destination.
+	ErrLookupFailed = errors.New("i2cp: destination lookup failed")
+	// ErrLookupUnsupported is returned for host name lookups on routers that
+	// only support b32 lookups.
+	ErrLookupUnsupported = errors.New("i2cp: router does not support host name lookups")
+	// ErrUnknownSession matches every *UnknownSessionError.
+	ErrUnknownSession = errors.New("i2cp: unknown session")
+	// ErrProtocolViolation matches every *ProtocolError.
+	ErrProtocolViolation = errors.New("i2cp: protocol violation")
+	// ErrUnsupportedSignatureType matches every *SignatureTypeError.
+	ErrUnsupportedSignatureType = errors.New("i2cp: unsupported signature type")
+	// ErrUnsupportedHashType is returned for hash algorithms other than HASH_SHA256.
+	ErrUnsupportedHashType = errors.New("i2cp: unsupported hash algorithm")
+	// ErrRouterDisconnected matches every *DisconnectError.
+	ErrRouterDisconnected = errors.New("i2cp: router disconnected")
+	// ErrInvalidKey is returned when key material has the wrong size.
+	ErrInvalidKey = errors.New("i2cp: invalid key")
+	// ErrQueueFull is returned when a message doesn't fit in the output queue.
+	ErrQueueFull = errors.New("i2cp: output queue is full")
+	// ErrKeepaliveTimeout is the cause of the disconnect when the router
+	// missed too many heartbeats.
+	ErrKeepaliveTimeout = errors.New("i2cp: router missed heartbeats")
+	// ErrRateLimited is returned by Session.SendMessage when a rate limit has
+	// no room for the message.
+	ErrRateLimited = errors.New("i2cp: rate limit exceeded")
+	// ErrProxyAuth is returned when the SOCKS5 proxy rejects the credentials
+	// or requires credentials that weren't configured.
+	ErrProxyAuth = errors.New("i2cp: socks5 proxy authentication failed")
+	// ErrReconfigureRefused is returned by Session.Reconfigure when the
+	// router doesn't accept the new config.
+	ErrReconfigureRefused = errors.New("i2cp: router refused to reconfigure the session")
+	// ErrSubsessionsUnsupported is returned by Session.CreateSubsession when
+	// the router is older than 0.9.21.
+	ErrSubsessionsUnsupported = errors.New("i2cp: router does not support subsessions")
+	// ErrSessionNotUsable matches every *SessionStateError.
+	ErrSessionNotUsable = errors.New("i2cp: session is not usable")
+	// ErrAuthFailed matches every *AuthError.
+	ErrAuthFailed = errors.New("i2cp: router authentication failed")
+	// ErrCredentialsFileMode is returned by FileCredentials for a file that
+	// group or others can access.
+	ErrCredentialsFileMode = errors.New("i2cp: credentials file is accessible by others")
+)
+
+// ProtocolError is returned when a message from the router can't be parsed
+// or isn't expected.
+type ProtocolError struct {
+	// Type is the message type, I2CP_MSG_ANY when the error isn't tied to
+	// a single message.
+	Type   uint8
+	Reason string
+	Err    error
+}
+
+func (e *ProtocolError) Error() string {
+	msg := fmt.Sprintf("i2cp: protocol violation in message type %d: %s", e.Type, e.Reason)
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *ProtocolError) Is(target error) bool {
+	return target == ErrProtocolViolation
+}
+
+func (e *ProtocolError) Unwrap() error {
+	return e.Err
+}
+
+// SessionStateError is returned when a session is used in a state that
+// doesn't allow it, e.g. SendMessage before the session was created.
+type SessionStateError struct {
+	SessionId uint16
+	State     SessionState
+}
+
+func (e *SessionStateError) Error() string {
+	return fmt.Sprintf("i2cp: session %d is %s", e.SessionId, e.State)
+}
+
+func (e *SessionStateError) Is(target error) bool {
+	return target == ErrSessionNotUsable
+}
+
+// UnknownSessionError is returned when the router refers to a session id the
+// client doesn't know.
+type UnknownSessionError struct {
+	SessionId uint16
+	// Type is the type of the message that carried the session id.
+	Type uint8
+}
+
+func (e *UnknownSessionError) Error() string {
+	return fmt.Sprintf("i2cp: message type %d for unknown session %d", e.Type, e.SessionId)
+}
+
+func (e *UnknownSessionError) Is(target error) bool {
+	return target == ErrUnknownSession
+}
+
+// DisconnectError describes why the connection to the router ended, Reason is
+// either the reason the router sent with its Disconnect message or the I/O
+// error in Err.
+type DisconnectError struct {
+	Reason string
+	Err    error
+}
+
+func (e *DisconnectError) Error() string {
+	return fmt.Sprintf("i2cp: router disconnected: %s", e.Reason)
+}
+
+func (e *DisconnectError) Is(target error) bool {
+	return target == ErrRouterDisconnected
+}
+
+func (e *DisconnectError) Unwrap() error {
+	return e.Err
+}
+
+// SignatureTypeError is returned for keys of a signature type the library
+// can't handle, only DSA_SHA1 is supported.
+type SignatureTypeError struct {
+	Type uint32
+}
+
+func (e *SignatureTypeError) Error() string {
+	return fmt.Sprintf("i2cp: unsupported signature type %d", e.Type)
+}
+
+func (e *SignatureTypeError) Is(target error) bool {
+	return target == ErrUnsupportedSignatureType
+}
+
+// TLSHandshakeError is returned by Connect when the TLS handshake with the
+// router fails, e.g. because the router doesn't speak TLS or its certificate
+// was rejected.
+type TLSHandshakeError struct {
+	Address string
+	Err     error
+}
+
+func (e *TLSHandshakeError) Error() string {
+	return fmt.Sprintf("i2cp: tls handshake with %s failed: %v", e.Address, e.Err)
+}
+
+func (e *TLSHandshakeError) Unwrap() error {
+	return e.Err
+}
+
+// AuthError is returned by Connect when the router disconnects during the
+// handshake after credentials were sent, or asks for credentials.
+type AuthError struct {
+	// Username is the user that was rejected, empty when no credentials
+	// were sent.
+	Username string
+	Err      error
+}
+
+func (e *AuthError) Error() string {
+	if e.Username == "" {
+		return fmt.Sprintf("i2cp: router requires authentication: %v", e.Err)
+	}
+	return fmt.Sprintf("i2cp: router rejected user %s: %v", e.Username, e.Err)
+}
+
+func (e *AuthError) Is(target error) bool {
+	return target == ErrAuthFailed
+}
+
+func (e *AuthError) Unwrap() error {
+	return e.Err
+}
+
+// ProxyError is returned when the router can't be reached through the SOCKS5
+// proxy, Err is the cause, e.g. ErrProxyAuth or a *SOCKS5ReplyError.
+type ProxyError struct {
+	Proxy   string
+	Address string
+	Err     error
+}
+
+func (e *ProxyError) Error() string {
+	return fmt.Sprintf("i2cp: connecting to %s through socks5 proxy %s: %v", e.Address, e.Proxy, e.Err)
+}
+
+func (e *ProxyError) Unwrap() error {
+	return e.Err
+}
+
+// SOCKS5ReplyError is returned when the SOCKS5 proxy refuses to connect to
+// the router.
+type SOCKS5ReplyError struct {
+	Reply uint8
+}
+
+func (e *SOCKS5ReplyError) Error() string {
+	if int(e.Reply) < len(socks5Replies) {
+		return "socks5 proxy: " + socks5Replies[e.Reply]
+	}
+	return fmt.Sprintf("socks5 proxy: unknown reply %d", e.Reply)
+}
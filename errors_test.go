@@ -0,0 +1,46 @@
+package go_i2cp
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCertificate_NullWithLength(t *testing.T) {
+	stream := NewStream(make([]byte, 0, 8))
+	stream.WriteByte(CERTIFICATE_NULL)
+	stream.WriteUint16(4)
+	_, err := NewCertificateFromMessage(stream)
+	if !errors.Is(err, ErrProtocolViolation) {
+		t.Fatalf("Expected a protocol violation, got %v", err)
+	}
+}
+
+func TestClient_PayloadUnknownSession(t *testing.T) {
+	client := NewClient(nil)
+	stream := NewStream(make([]byte, 0, 16))
+	stream.WriteUint16(42)
+	stream.WriteUint32(1)
+	stream.WriteUint32(0)
+	err := client.onMessage(I2CP_MSG_PAYLOAD_MESSAGE, stream)
+	var unknown *UnknownSessionError
+	if !errors.As(err, &unknown) || unknown.SessionId != 42 {
+		t.Fatalf("Expected an UnknownSessionError for session 42, got %v", err)
+	}
+	if !errors.Is(err, ErrUnknownSession) {
+		t.Fatal("UnknownSessionError should match ErrUnknownSession")
+	}
+}
+
+func TestClient_DisconnectReason(t *testing.T) {
+	client := NewClient(nil)
+	stream := NewStream(make([]byte, 0, 16))
+	stream.WriteLenPrefixedString("router shutdown")
+	err := client.onMessage(I2CP_MSG_DISCONNECT, stream)
+	var disconnect *DisconnectError
+	if !errors.As(err, &disconnect) || disconnect.Reason != "router shutdown" {
+		t.Fatalf("Expected a DisconnectError with the router's reason, got %v", err)
+	}
+	if !errors.Is(err, ErrRouterDisconnected) {
+		t.Fatal("DisconnectError should match ErrRouterDisconnected")
+	}
+}
@@ -0,0 +1,452 @@
+// Package i2cptest implements the router side of I2CP so clients can be
+// tested without a running I2P router.
+//
+// A Router answers GetDate and GetBandwidthLimits, creates and destroys
+// sessions, requests a lease set for every new session, resolves lookups from
+// its host table and the destinations of its own sessions, and delivers
+// SendMessage payloads to the sessions on the same Router. Like a real
+// router it treats every session after the first one on a connection as a
+// subsession of the first, destroying the primary destroys its subsessions.
+package i2cptest
+
+import (
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wkoomson/go-i2cp/message"
+)
+
+// DefaultVersion is the router version sent in SetDate.
+const DefaultVersion = "0.9.33"
+
+const protocolInit = 0x2a
+
+const (
+	statusAccepted          uint8 = 1
+	statusGuaranteedSuccess uint8 = 4
+	statusNoLeaseSet        uint8 = 21
+)
+
+// ErrRouterClosed is returned by Serve after Close.
+var ErrRouterClosed = errors.New("i2cptest: router closed")
+
+// ErrAuthFailed is returned by ServeConn when the client sent wrong or no
+// credentials.
+var ErrAuthFailed = errors.New("i2cptest: client authentication failed")
+
+// Router is an in-process I2CP router. The zero value is not usable, create
+// one with NewRouter.
+type Router struct {
+	// Version is the router version sent to clients in SetDate.
+	Version string
+	// OnReceive, if set, is called with every message a client sends before
+	// the router handles it. It is called from the connection's goroutine.
+	OnReceive func(typ uint8, body []byte)
+	// BandwidthLimits is sent in reply to GetBandwidthLimits.
+	BandwidthLimits message.BandwidthLimits
+	// Now, if set, is the router's clock, e.g. to simulate clock skew. It
+	// defaults to time.Now.
+	Now func() time.Time
+	// Username and Password, if Username is set, must be sent in GetDate.
+	// Clients with other credentials are disconnected like a router with
+	// i2cp.auth=true does.
+	Username, Password string
+	// Reconfigure, if set, decides whether the options of a
+	// ReconfigureSession are accepted. Every change is accepted if nil.
+	Reconfigure func(sessionId uint16, options map[string]string) bool
+
+	lock        sync.Mutex
+	hosts       map[string][]byte
+	sessions    map[uint16]*session
+	conns       map[*conn]struct{}
+	listeners   map[net.Listener]struct{}
+	nextSession uint16
+	nextMessage uint32
+	closed      bool
+}
+
+type conn struct {
+	net.Conn
+	writeLock sync.Mutex
+}
+
+type session struct {
+	id          uint16
+	conn        *conn
+	destination []byte
+	hash        message.Hash
+	// primary is the session whose tunnels a subsession shares
+	primary *session
+}
+
+// NewRouter creates a Router without any hosts or sessions.
+func NewRouter() *Router {
+	return &Router{
+		Version: DefaultVersion,
+		BandwidthLimits: message.BandwidthLimits{
+			ClientInbound:       256,
+			ClientOutbound:      256,
+			RouterInbound:       512,
+			RouterInboundBurst:  768,
+			RouterOutbound:      512,
+			RouterOutboundBurst: 768,
+			RouterBurstTime:     20,
+		},
+		hosts:     make(map[string][]byte),
+		sessions:  make(map[uint16]*session),
+		conns:     make(map[*conn]struct{}),
+		listeners: make(map[net.Listener]struct{}),
+	}
+}
+
+// AddHost adds name to the host table, destination is the destination in
+// I2CP wire format. It can then be looked up by name and by hash.
+func (r *Router) AddHost(name string, destination []byte) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.hosts[name] = append([]byte(nil), destination...)
+}
+
+// Serve accepts client connections on ln until ln fails or the router is
+// closed.
+func (r *Router) Serve(ln net.Listener) error {
+	r.lock.Lock()
+	if r.closed {
+		r.lock.Unlock()
+		ln.Close()
+		return ErrRouterClosed
+	}
+	r.listeners[ln] = struct{}{}
+	r.lock.Unlock()
+	defer func() {
+		r.lock.Lock()
+		delete(r.listeners, ln)
+		r.lock.Unlock()
+	}()
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			if r.isClosed() {
+				return ErrRouterClosed
+			}
+			return err
+		}
+		go r.ServeConn(c)
+	}
+}
+
+// ServeConn runs the I2CP protocol on a single client connection, e.g. the
+// router end of a pipe, until the client disconnects. The sessions of the
+// connection are destroyed when it returns.
+func (r *Router) ServeConn(nc net.Conn) error {
+	c := &conn{Conn: nc}
+	r.lock.Lock()
+	if r.closed {
+		r.lock.Unlock()
+		nc.Close()
+		return ErrRouterClosed
+	}
+	r.conns[c] = struct{}{}
+	r.lock.Unlock()
+	defer r.drop(c)
+
+	protocol := make([]byte, 1)
+	if _, err := io.ReadFull(c, protocol); err != nil {
+		return err
+	}
+	if protocol[0] != protocolInit {
+		return fmt.Errorf("i2cptest: unexpected protocol byte %#x", protocol[0])
+	}
+	for {
+		typ, body, err := message.ReadFrame(c, message.MaxSize)
+		if err != nil {
+			if err == io.EOF || r.isClosed() {
+				return nil
+			}
+			return err
+		}
+		if r.OnReceive != nil {
+			r.OnReceive(typ, body)
+		}
+		if typ == message.TypeDisconnect {
+			return nil
+		}
+		m, err := message.Decode(typ, body)
+		if errors.Is(err, message.ErrUnknownType) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err = r.handle(c, m); err != nil {
+			return err
+		}
+	}
+}
+
+// Sessions returns the number of sessions on the router.
+func (r *Router) Sessions() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return len(r.sessions)
+}
+
+// Disconnect sends a Disconnect message with reason to every client and
+// closes their connections, as a router does when it shuts down. Listeners
+// keep accepting new connections.
+func (r *Router) Disconnect(reason string) {
+	r.lock.Lock()
+	conns := make([]*conn, 0, len(r.conns))
+	for c := range r.conns {
+		conns = append(conns, c)
+	}
+	r.lock.Unlock()
+	for _, c := range conns {
+		c.send(&message.Disconnect{Reason: reason})
+		c.Close()
+	}
+}
+
+// Close stops the listeners and closes every client connection.
+func (r *Router) Close() error {
+	r.lock.Lock()
+	r.closed = true
+	for ln := range r.listeners {
+		ln.Close()
+	}
+	conns := make([]*conn, 0, len(r.conns))
+	for c := range r.conns {
+		conns = append(conns, c)
+	}
+	r.lock.Unlock()
+	for _, c := range conns {
+		c.Close()
+	}
+	return nil
+}
+
+func (r *Router) isClosed() bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.closed
+}
+
+// drop forgets a connection and its sessions.
+func (r *Router) drop(c *conn) {
+	c.Close()
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.conns, c)
+	for id, s := range r.sessions {
+		if s.conn == c {
+			delete(r.sessions, id)
+		}
+	}
+}
+
+func (r *Router) handle(c *conn, m message.Message) error {
+	switch m := m.(type) {
+	case *message.GetDate:
+		if r.Username != "" && (m.Options["i2cp.username"] != r.Username || m.Options["i2cp.password"] != r.Password) {
+			c.send(&message.Disconnect{Reason: "Authorization failed"})
+			return ErrAuthFailed
+		}
+		return c.send(&message.SetDate{Date: millis(r.now()), Version: r.Version})
+	case *message.CreateSession:
+		return r.onCreateSession(c, m)
+	case *message.ReconfigureSession:
+		status := message.SessionUpdated
+		if r.session(c, m.SessionId) == nil || (r.Reconfigure != nil && !r.Reconfigure(m.SessionId, m.Options)) {
+			status = message.SessionInvalid
+		}
+		return c.send(&message.SessionStatus{SessionId: m.SessionId, Status: status})
+	case *message.DestroySession:
+		return r.onDestroySession(c, m)
+	case *message.SendMessage:
+		return r.onSendMessage(c, m)
+	case *message.HostLookup:
+		return r.onHostLookup(c, m)
+	case *message.GetBandwidthLimits:
+		limits := r.BandwidthLimits
+		return c.send(&limits)
+	case *message.DestLookup:
+		if destination := r.lookupHash(m.Hash); destination != nil {
+			return c.send(&message.DestReply{Destination: destination})
+		}
+		return c.send(&message.DestReply{Hash: m.Hash})
+	}
+	// CreateLeaseSet and everything else is accepted silently
+	return nil
+}
+
+func (r *Router) onCreateSession(c *conn, m *message.CreateSession) error {
+	s := &session{conn: c, destination: m.Destination, hash: sha256.Sum256(m.Destination)}
+	r.lock.Lock()
+	for _, other := range r.sessions {
+		if other.conn == c && other.primary == nil {
+			s.primary = other
+			break
+		}
+	}
+	r.nextSession++
+	s.id = r.nextSession
+	r.sessions[s.id] = s
+	r.lock.Unlock()
+	if err := c.send(&message.SessionStatus{SessionId: s.id, Status: message.SessionCreated}); err != nil {
+		return err
+	}
+	// one lease through a made up gateway, valid for ten minutes
+	lease := message.Lease{
+		Gateway:  sha256.Sum256([]byte("i2cptest gateway")),
+		TunnelId: uint32(s.id),
+		EndDate:  millis(r.now().Add(10 * time.Minute)),
+	}
+	return c.send(&message.RequestVariableLeaseSet{SessionId: s.id, Leases: []message.Lease{lease}})
+}
+
+// onDestroySession destroys a session, and the subsessions with their
+// primary. The subsessions are reported destroyed first.
+func (r *Router) onDestroySession(c *conn, m *message.DestroySession) error {
+	r.lock.Lock()
+	var destroyed []uint16
+	if s := r.sessions[m.SessionId]; s != nil && s.conn == c {
+		for id, sub := range r.sessions {
+			if sub.primary == s {
+				destroyed = append(destroyed, id)
+			}
+		}
+		sort.Slice(destroyed, func(i, j int) bool { return destroyed[i] < destroyed[j] })
+		for _, id := range destroyed {
+			delete(r.sessions, id)
+		}
+		delete(r.sessions, m.SessionId)
+	}
+	r.lock.Unlock()
+	for _, id := range append(destroyed, m.SessionId) {
+		if err := c.send(&message.SessionStatus{SessionId: id, Status: message.SessionDestroyed}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Router) onSendMessage(c *conn, m *message.SendMessage) error {
+	hash := sha256.Sum256(m.Destination)
+	r.lock.Lock()
+	r.nextMessage++
+	messageId := r.nextMessage
+	var target *session
+	for _, s := range r.sessions {
+		if s.hash == hash {
+			target = s
+			break
+		}
+	}
+	r.lock.Unlock()
+	status := statusNoLeaseSet
+	if target != nil {
+		status = statusGuaranteedSuccess
+		err := target.conn.send(&message.MessagePayload{SessionId: target.id, MessageId: messageId, Payload: m.Payload})
+		if err != nil && target.conn == c {
+			return err
+		}
+	}
+	// the router only reports the status of messages sent with a nonce
+	if m.Nonce == 0 {
+		return nil
+	}
+	reply := &message.MessageStatus{SessionId: m.SessionId, MessageId: messageId, Status: statusAccepted, Size: uint32(len(m.Payload)), Nonce: m.Nonce}
+	if err := c.send(reply); err != nil {
+		return err
+	}
+	reply.Status = status
+	return c.send(reply)
+}
+
+func (r *Router) onHostLookup(c *conn, m *message.HostLookup) error {
+	var destination []byte
+	switch m.LookupType {
+	case message.LookupHash:
+		destination = r.lookupHash(m.Hash)
+	case message.LookupHost:
+		destination = r.lookupHost(m.Host)
+	}
+	reply := &message.HostReply{SessionId: m.SessionId, RequestId: m.RequestId, Destination: destination}
+	if destination == nil {
+		reply.Result = 1
+	}
+	return c.send(reply)
+}
+
+func (r *Router) session(c *conn, id uint16) *session {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if s := r.sessions[id]; s != nil && s.conn == c {
+		return s
+	}
+	return nil
+}
+
+func (r *Router) lookupHash(hash message.Hash) []byte {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, s := range r.sessions {
+		if s.hash == hash {
+			return s.destination
+		}
+	}
+	for _, destination := range r.hosts {
+		if sha256.Sum256(destination) == hash {
+			return destination
+		}
+	}
+	return nil
+}
+
+func (r *Router) lookupHost(name string) []byte {
+	r.lock.Lock()
+	destination := r.hosts[name]
+	r.lock.Unlock()
+	if destination != nil {
+		return destination
+	}
+	if !strings.HasSuffix(name, ".b32.i2p") {
+		return nil
+	}
+	label := strings.ToUpper(strings.TrimRight(strings.TrimSuffix(name, ".b32.i2p"), "="))
+	if pad := len(label) % 8; pad != 0 {
+		label += strings.Repeat("=", 8-pad)
+	}
+	decoded, err := base32.StdEncoding.DecodeString(label)
+	if err != nil || len(decoded) != sha256.Size {
+		return nil
+	}
+	var hash message.Hash
+	copy(hash[:], decoded)
+	return r.lookupHash(hash)
+}
+
+func (r *Router) now() time.Time {
+	if r.Now != nil {
+		return r.Now()
+	}
+	return time.Now()
+}
+
+func millis(t time.Time) uint64 {
+	return uint64(t.UnixNano() / int64(time.Millisecond))
+}
+
+func (c *conn) send(m message.Message) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	return message.Write(c, m)
+}
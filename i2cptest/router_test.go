@@ -0,0 +1,68 @@
+package i2cptest
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/wkoomson/go-i2cp/message"
+)
+
+func dial(t *testing.T, router *Router) net.Conn {
+	client, server := net.Pipe()
+	go router.ServeConn(server)
+	if _, err := client.Write([]byte{protocolInit}); err != nil {
+		t.Fatalf("Could not send protocol byte: %s", err.Error())
+	}
+	return client
+}
+
+func exchange(t *testing.T, c net.Conn, m message.Message) message.Message {
+	go message.Write(c, m)
+	reply, err := message.Read(c)
+	if err != nil {
+		t.Fatalf("Could not read reply to message type %d: %s", m.Type(), err.Error())
+	}
+	return reply
+}
+
+func TestRouter_GetDate(t *testing.T) {
+	router := NewRouter()
+	router.Version = "0.9.50"
+	client := dial(t, router)
+	defer client.Close()
+
+	reply, ok := exchange(t, client, &message.GetDate{Version: "0.9.33"}).(*message.SetDate)
+	if !ok {
+		t.Fatalf("Expected SetDate, got %T", reply)
+	}
+	if reply.Version != "0.9.50" {
+		t.Fatalf("Expected version 0.9.50, got '%s'", reply.Version)
+	}
+}
+
+func TestRouter_HostLookup(t *testing.T) {
+	router := NewRouter()
+	destination := make([]byte, message.DestinationKeysSize+3)
+	destination[0] = 1
+	router.AddHost("known.i2p", destination)
+	client := dial(t, router)
+	defer client.Close()
+
+	for _, tc := range []struct {
+		name   string
+		result uint8
+	}{{"known.i2p", 0}, {"unknown.i2p", 1}} {
+		lookup := &message.HostLookup{SessionId: 1, RequestId: 42, Timeout: 1000, LookupType: message.LookupHost, Host: tc.name}
+		reply, ok := exchange(t, client, lookup).(*message.HostReply)
+		if !ok || reply.RequestId != 42 {
+			t.Fatalf("Expected HostReply to request 42, got %+v", reply)
+		}
+		if reply.Result != tc.result {
+			t.Fatalf("Lookup of %s: expected result %d, got %d", tc.name, tc.result, reply.Result)
+		}
+		if tc.result == 0 && !bytes.Equal(reply.Destination, destination) {
+			t.Fatalf("Lookup of %s returned the wrong destination", tc.name)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package go_i2cp
+
+import "context"
+
+// startIO starts the goroutines that read frames from the router, write the
+// output queue and send heartbeats, they run until the connection is lost
+// for good or the client disconnects.
+func (c *Client) startIO() {
+	done := make(chan struct{})
+	c.lock.Lock()
+	c.done = done
+	c.ioErr = nil
+	keepalive := c.keepalivePolicy
+	c.lock.Unlock()
+	go c.readLoop(done)
+	go c.writeLoop(done)
+	if keepalive != nil {
+		go c.keepaliveLoop(done, keepalive)
+	}
+}
+
+// stopIO ends the I/O goroutines of the current connection, err is reported
+// by Run and ProcessIO.
+func (c *Client) stopIO(err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.done == nil {
+		return
+	}
+	select {
+	case <-c.done:
+		return
+	default:
+	}
+	c.ioErr = err
+	close(c.done)
+}
+
+func (c *Client) readLoop(done chan struct{}) {
+	for {
+		msgType, stream, err := c.readMessage(I2CP_MSG_ANY)
+		if err == nil {
+			err = c.onMessage(msgType, stream)
+			if _, ok := err.(*DisconnectError); !ok && err != nil {
+				// a bad message doesn't break the connection
+				c.reportError(err)
+				continue
+			}
+		}
+		select {
+		case <-done:
+			return
+		default:
+		}
+		if err == nil {
+			continue
+		}
+		c.transport.Close()
+		if c.isClosed() {
+			c.stopIO(nil)
+			return
+		}
+		if keepaliveErr := c.takeKeepaliveErr(); keepaliveErr != nil {
+			err = keepaliveErr
+		}
+		reason := err.Error()
+		if disconnect, ok := err.(*DisconnectError); ok {
+			reason = disconnect.Reason
+		} else {
+			err = &DisconnectError{Reason: reason, Err: err}
+		}
+		if c.handleDisconnect(reason) {
+			continue
+		}
+		c.stopIO(err)
+		return
+	}
+}
+
+// reportError passes errors without a caller to return them to to the
+// OnError callback.
+func (c *Client) reportError(err error) {
+	Error(TAG, "%s", err.Error())
+	if c.callbacks != nil && c.callbacks.OnError != nil {
+		c.callbacks.OnError(c, err)
+	}
+}
+
+func (c *Client) writeLoop(done chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		case <-c.outputReady:
+		}
+		if err := c.flushOutputQueue(); err != nil {
+			// the reader notices the closed transport and takes care of
+			// reconnecting
+			Error(TAG, "Error while sending queued messages: %s", err.Error())
+			c.transport.Close()
+		}
+	}
+}
+
+// flushOutputQueue sends everything queued so far, it's a no-op while
+// reconnecting as the queue belongs to the next connection. It returns once
+// a flush in progress on another goroutine is done as well.
+func (c *Client) flushOutputQueue() error {
+	c.flushLock.Lock()
+	defer c.flushLock.Unlock()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for !c.reconnecting && c.outputQueue.len() > 0 {
+		batch, size := c.outputQueue.take(c.queueConfig.CoalesceSize)
+		c.lock.Unlock()
+		err := c.sendBatch(batch, size)
+		c.lock.Lock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendBatch writes frames with a single Send.
+func (c *Client) sendBatch(batch []*Stream, size int) error {
+	out := batch[0]
+	if len(batch) > 1 {
+		out = NewStream(make([]byte, 0, size))
+		for _, frame := range batch {
+			out.Write(frame.Bytes())
+		}
+	}
+	Debug(TAG|PROTOCOL, "Sending %d messages in %d bytes", len(batch), size)
+	_, err := c.send(out)
+	return err
+}
+
+// notifyOutput wakes up the writer goroutine.
+func (c *Client) notifyOutput() {
+	select {
+	case c.outputReady <- struct{}{}:
+	default:
+	}
+}
+
+// send writes a complete frame to the transport.
+func (c *Client) send(stream *Stream) (int, error) {
+	c.sendLock.Lock()
+	defer c.sendLock.Unlock()
+	return c.transport.Send(stream)
+}
+
+// Run blocks until ctx is done or the connection to the router is lost and
+// could not be re-established. Cancelling ctx disconnects the client.
+// Messages are dispatched to the callbacks from the client's reader
+// goroutine as soon as they arrive, Run is only needed to wait for the end
+// of the connection.
+func (c *Client) Run(ctx context.Context) error {
+	c.lock.Lock()
+	done := c.done
+	c.lock.Unlock()
+	if done == nil {
+		return ErrNotConnected
+	}
+	select {
+	case <-ctx.Done():
+		c.Disconnect()
+		return ctx.Err()
+	case <-done:
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		return c.ioErr
+	}
+}
+
+// ProcessIO is kept for compatibility, reading and writing happens in the
+// background since Connect. It returns the error that stopped the client's
+// I/O, if any.
+func (c *Client) ProcessIO() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.ioErr
+}
+
+func (c *Client) isClosed() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.closed
+}
+
+// transportReader adapts a Transport to io.Reader.
+type transportReader struct {
+	transport Transport
+}
+
+func (r transportReader) Read(p []byte) (int, error) {
+	return r.transport.Receive(NewStream(p))
+}
@@ -0,0 +1,177 @@
+package go_i2cp
+
+import (
+	"fmt"
+	"time"
+)
+
+// KeepalivePolicy configures the heartbeat on an idle router connection.
+// When no message arrived from the router for Interval the client sends a
+// GetDate and measures the time until the SetDate answer. Heartbeats are
+// disabled unless a policy is set with Client.SetKeepalivePolicy.
+type KeepalivePolicy struct {
+	// Interval is the idle time before a heartbeat is sent.
+	Interval time.Duration
+	// Timeout is the time the router has to answer a heartbeat.
+	Timeout time.Duration
+	// MaxMissed is the number of unanswered heartbeats in a row after which
+	// the connection is treated as lost, at least 1.
+	MaxMissed int
+}
+
+func DefaultKeepalivePolicy() *KeepalivePolicy {
+	return &KeepalivePolicy{
+		Interval:  30 * time.Second,
+		Timeout:   10 * time.Second,
+		MaxMissed: 2,
+	}
+}
+
+// withDefaults returns a copy of the policy with the fields that aren't
+// positive taken from DefaultKeepalivePolicy.
+func (p *KeepalivePolicy) withDefaults() *KeepalivePolicy {
+	defaults := DefaultKeepalivePolicy()
+	policy := *p
+	if policy.Interval <= 0 {
+		policy.Interval = defaults.Interval
+	}
+	if policy.Timeout <= 0 {
+		policy.Timeout = defaults.Timeout
+	}
+	if policy.MaxMissed < 1 {
+		policy.MaxMissed = defaults.MaxMissed
+	}
+	return &policy
+}
+
+// tick returns how often the keepalive goroutine checks the connection, at
+// least every millisecond.
+func (p *KeepalivePolicy) tick() time.Duration {
+	tick := p.Interval
+	if p.Timeout < tick {
+		tick = p.Timeout
+	}
+	tick /= 4
+	if tick < time.Millisecond {
+		tick = time.Millisecond
+	}
+	return tick
+}
+
+// SetKeepalivePolicy enables heartbeats with the given policy, nil disables
+// them. Fields that aren't positive are replaced by the ones of
+// DefaultKeepalivePolicy. It takes effect with the next Connect.
+func (c *Client) SetKeepalivePolicy(policy *KeepalivePolicy) {
+	if policy != nil {
+		policy = policy.withDefaults()
+	}
+	c.lock.Lock()
+	c.keepalivePolicy = policy
+	c.lock.Unlock()
+}
+
+// RTT returns the round-trip time of the latest GetDate answered by the
+// router, including the one of the handshake. It is zero before the first
+// answer.
+func (c *Client) RTT() time.Duration {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.rtt
+}
+
+// LastSeen returns the time the latest message from the router arrived.
+func (c *Client) LastSeen() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.lastSeen
+}
+
+// pingSent remembers when a GetDate went out, the answer is timed in
+// onMsgSetDate.
+func (c *Client) pingSent(now time.Time) {
+	c.lock.Lock()
+	c.pingTime = now
+	c.lock.Unlock()
+}
+
+// seen records that a message arrived from the router.
+func (c *Client) seen(now time.Time) {
+	c.lock.Lock()
+	c.lastSeen = now
+	c.lock.Unlock()
+}
+
+// pong completes the round trip of the outstanding GetDate and returns its
+// duration, 0 if no GetDate was outstanding.
+func (c *Client) pong(now time.Time) time.Duration {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.pingTime.IsZero() {
+		return 0
+	}
+	c.rtt = now.Sub(c.pingTime)
+	c.pingTime = time.Time{}
+	c.missedPings = 0
+	Debug(TAG, "Router round-trip time %s", c.rtt)
+	return c.rtt
+}
+
+func (c *Client) keepaliveLoop(done chan struct{}, policy *KeepalivePolicy) {
+	ticker := time.NewTicker(policy.tick())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			c.checkAlive(now, policy)
+		}
+	}
+}
+
+// checkAlive sends a heartbeat on an idle connection and closes the
+// transport once too many went unanswered, the reader goroutine then handles
+// the disconnect.
+func (c *Client) checkAlive(now time.Time, policy *KeepalivePolicy) {
+	c.lock.Lock()
+	if c.reconnecting || c.closed {
+		c.lock.Unlock()
+		return
+	}
+	if !c.pingTime.IsZero() {
+		if now.Sub(c.pingTime) < policy.Timeout {
+			c.lock.Unlock()
+			return
+		}
+		c.missedPings++
+		c.pingTime = time.Time{}
+		Warning(TAG, "Router did not answer heartbeat %d within %s", c.missedPings, policy.Timeout)
+		if c.missedPings >= policy.MaxMissed {
+			c.keepaliveErr = &DisconnectError{
+				Reason: fmt.Sprintf("missed %d heartbeats", c.missedPings),
+				Err:    ErrKeepaliveTimeout,
+			}
+			c.lock.Unlock()
+			c.transport.Close()
+			return
+		}
+	} else if now.Sub(c.lastSeen) < policy.Interval {
+		c.lock.Unlock()
+		return
+	}
+	c.pingTime = now
+	c.lock.Unlock()
+	Debug(TAG, "Sending heartbeat to idle router connection")
+	if err := c.msgGetDate(nil, true); err != nil {
+		Warning(TAG, "Could not queue heartbeat: %s", err.Error())
+	}
+}
+
+// takeKeepaliveErr returns and clears the error of a failed heartbeat.
+func (c *Client) takeKeepaliveErr() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	err := c.keepaliveErr
+	c.keepaliveErr = nil
+	return err
+}
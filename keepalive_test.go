@@ -0,0 +1,103 @@
+package go_i2cp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/wkoomson/go-i2cp/message"
+)
+
+func TestClient_Keepalive(t *testing.T) {
+	router, client := startRouter(t)
+	heartbeats := make(chan struct{}, 16)
+	router.OnReceive = func(typ uint8, body []byte) {
+		if typ == I2CP_MSG_GET_DATE {
+			heartbeats <- struct{}{}
+		}
+	}
+	client.SetKeepalivePolicy(&KeepalivePolicy{Interval: 20 * time.Millisecond, Timeout: time.Second, MaxMissed: 1})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.ConnectContext(ctx); err != nil {
+		t.Fatalf("Could not connect: %s", err.Error())
+	}
+	defer client.Disconnect()
+	if client.RTT() <= 0 {
+		t.Fatal("Expected the round-trip time of the handshake")
+	}
+	<-heartbeats
+	seen := client.LastSeen()
+	for i := 0; i < 2; i++ {
+		select {
+		case <-heartbeats:
+		case <-ctx.Done():
+			t.Fatal("Client did not send heartbeats on the idle connection")
+		}
+	}
+	// the router answered the first heartbeat by now
+	if !client.LastSeen().After(seen) {
+		t.Fatal("Expected the answer to a heartbeat to update LastSeen")
+	}
+	if !client.IsConnected() {
+		t.Fatal("Client should still be connected")
+	}
+}
+
+func TestClient_KeepaliveTimeout(t *testing.T) {
+	disconnected := make(chan string, 1)
+	client := NewClient(&ClientCallBacks{onDisconnect: func(c *Client, reason string, opaque *interface{}) {
+		disconnected <- reason
+	}})
+	pipe, router := NewPipe()
+	defer router.Close()
+	go func() {
+		// answer the handshake, then ignore the heartbeats
+		protocol := make([]byte, 1)
+		if _, err := io.ReadFull(router, protocol); err != nil {
+			return
+		}
+		if _, _, err := message.ReadFrame(router, I2CP_MESSAGE_SIZE); err != nil {
+			return
+		}
+		message.Write(router, &message.SetDate{Date: uint64(time.Now().Unix() * 1000), Version: "0.9.33"})
+		io.Copy(io.Discard, router)
+	}()
+	client.SetTransport(pipe)
+	client.SetKeepalivePolicy(&KeepalivePolicy{Interval: 10 * time.Millisecond, Timeout: 20 * time.Millisecond, MaxMissed: 2})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.ConnectContext(ctx); err != nil {
+		t.Fatalf("Could not connect: %s", err.Error())
+	}
+	select {
+	case reason := <-disconnected:
+		if reason != "missed 2 heartbeats" {
+			t.Fatalf("Unexpected disconnect reason '%s'", reason)
+		}
+	case <-ctx.Done():
+		t.Fatal("Missed heartbeats did not disconnect the client")
+	}
+	if err := client.Run(ctx); !errors.Is(err, ErrKeepaliveTimeout) {
+		t.Fatalf("Expected ErrKeepaliveTimeout, got %v", err)
+	}
+}
+
+func TestClient_SetKeepalivePolicyDefaults(t *testing.T) {
+	client := NewClient(nil)
+	policy := &KeepalivePolicy{Interval: 2 * time.Nanosecond}
+	client.SetKeepalivePolicy(policy)
+	got := client.keepalivePolicy
+	if got.Interval != 2*time.Nanosecond || got.Timeout != DefaultKeepalivePolicy().Timeout || got.MaxMissed != DefaultKeepalivePolicy().MaxMissed {
+		t.Fatalf("Expected the missing fields to be defaulted, got %+v", *got)
+	}
+	if policy.Timeout != 0 {
+		t.Fatal("SetKeepalivePolicy changed the caller's policy")
+	}
+	// time.NewTicker panics for a non-positive duration
+	if tick := got.tick(); tick <= 0 {
+		t.Fatalf("Expected a positive tick, got %s", tick)
+	}
+}
@@ -1,5 +1,7 @@
 package go_i2cp
 
+import "io"
+
 type Lease struct {
 	tunnelGateway [32]byte // sha256 of the RouterIdentity of the tunnel gateway
 	tunnelId      uint32
@@ -8,15 +10,19 @@ type Lease struct {
 
 func NewLeaseFromStream(stream *Stream) (l *Lease, err error) {
 	l = &Lease{}
-	stream.Read(l.tunnelGateway[:])
-	l.tunnelId, err = stream.ReadUint32()
+	if _, err = io.ReadFull(stream, l.tunnelGateway[:]); err != nil {
+		return
+	}
+	if l.tunnelId, err = stream.ReadUint32(); err != nil {
+		return
+	}
 	l.endDate, err = stream.ReadUint64()
 	return
 }
 
 func (l *Lease) WriteToMessage(stream *Stream) (err error) {
-	_, err = stream.Write(l.tunnelGateway[:])
-	err = stream.WriteUint32(l.tunnelId)
+	stream.Write(l.tunnelGateway[:])
+	stream.WriteUint32(l.tunnelId)
 	err = stream.WriteUint64(l.endDate)
 	return
 }
@@ -1,6 +1,9 @@
 package go_i2cp
 
-import "fmt"
+import (
+	"fmt"
+	"sync/atomic"
+)
 
 const (
 	PROTOCOL = 1 << 0
@@ -46,34 +49,44 @@ type Logger struct {
 	logLevel  int
 }
 
-var logInstance = &Logger{}
+// logInstance is replaced by LogInit while other goroutines log.
+var logInstance atomic.Pointer[Logger]
+
+func init() {
+	logInstance.Store(&Logger{})
+}
 
 // TODO filter
 func LogInit(callbacks *LoggerCallbacks, level int) {
-	logInstance = &Logger{callbacks: callbacks}
-	logInstance.setLogLevel(level)
+	logger := &Logger{callbacks: callbacks}
+	logger.setLogLevel(level)
+	logInstance.Store(logger)
 }
 func Debug(tags LoggerTags, message string, args ...interface{}) {
-	logInstance.log(tags|DEBUG, message, args...)
+	logInstance.Load().log(tags|DEBUG, message, args...)
 }
 func Info(tags LoggerTags, message string, args ...interface{}) {
-	logInstance.log(tags|INFO, message, args...)
+	logInstance.Load().log(tags|INFO, message, args...)
 }
 func Warning(tags LoggerTags, message string, args ...interface{}) {
-	logInstance.log(tags|WARNING, message, args...)
+	logInstance.Load().log(tags|WARNING, message, args...)
 }
 func Error(tags LoggerTags, message string, args ...interface{}) {
-	logInstance.log(tags|ERROR, message, args...)
+	logInstance.Load().log(tags|ERROR, message, args...)
 }
 func Fatal(tags LoggerTags, message string, args ...interface{}) {
-	logInstance.log(tags|FATAL, message, args...)
+	logInstance.Load().log(tags|FATAL, message, args...)
 }
 
+// log writes a line, passwords must not be passed to it. Fields that carry
+// them are redacted where they are logged, see message.RedactOptions and
+// url.URL.Redacted.
 func (l *Logger) log(tags LoggerTags, format string, args ...interface{}) {
+	line := fmt.Sprintf(format, args...)
 	if l.callbacks == nil {
-		fmt.Printf(format+"\n", args)
+		fmt.Println(line)
 	} else {
-		l.callbacks.onLog(l, tags, fmt.Sprintf(format, args))
+		l.callbacks.onLog(l, tags, line)
 	}
 }
 
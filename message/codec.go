@@ -0,0 +1,201 @@
+package message
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// decoder reads I2CP primitives from a message body, the first error sticks
+// and every later read returns zero values.
+type decoder struct {
+	typ uint8
+	b   []byte
+	err error
+}
+
+func (d *decoder) fail(what string) {
+	if d.err == nil {
+		d.err = &DecodeError{Type: d.typ, Reason: "truncated " + what}
+	}
+}
+
+func (d *decoder) bytes(n int, what string) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || len(d.b) < n {
+		d.fail(what)
+		return nil
+	}
+	b := make([]byte, n)
+	copy(b, d.b)
+	d.b = d.b[n:]
+	return b
+}
+
+func (d *decoder) uint8(what string) uint8 {
+	if b := d.bytes(1, what); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *decoder) uint16(what string) uint16 {
+	if b := d.bytes(2, what); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (d *decoder) uint32(what string) uint32 {
+	if b := d.bytes(4, what); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (d *decoder) uint64(what string) uint64 {
+	if b := d.bytes(8, what); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (d *decoder) hash(what string) (h Hash) {
+	copy(h[:], d.bytes(len(h), what))
+	return
+}
+
+func (d *decoder) string(what string) string {
+	return string(d.bytes(int(d.uint8(what+" length")), what))
+}
+
+func (d *decoder) mapping(what string) map[string]string {
+	size := d.uint16(what + " size")
+	inner := decoder{typ: d.typ, b: d.bytes(int(size), what)}
+	if d.err != nil {
+		return nil
+	}
+	m := make(map[string]string)
+	for len(inner.b) > 0 && inner.err == nil {
+		key := inner.string(what + " key")
+		if inner.uint8(what) != '=' && inner.err == nil {
+			inner.err = &DecodeError{Type: d.typ, Reason: "missing '=' in " + what}
+		}
+		value := inner.string(what + " value")
+		if inner.uint8(what) != ';' && inner.err == nil {
+			inner.err = &DecodeError{Type: d.typ, Reason: "missing ';' in " + what}
+		}
+		m[key] = value
+	}
+	if inner.err != nil {
+		d.err = inner.err
+		return nil
+	}
+	return m
+}
+
+// destination reads a destination, 384 bytes of keys followed by a
+// certificate, and returns its wire bytes.
+func (d *decoder) destination(what string) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if len(d.b) < DestinationKeysSize+3 {
+		d.fail(what)
+		return nil
+	}
+	certLen := int(binary.BigEndian.Uint16(d.b[DestinationKeysSize+1:]))
+	return d.bytes(DestinationKeysSize+3+certLen, what)
+}
+
+// rest returns everything that hasn't been read yet.
+func (d *decoder) rest() []byte {
+	return d.bytes(len(d.b), "")
+}
+
+// encoder appends I2CP primitives to a message body.
+type encoder struct {
+	typ uint8
+	b   []byte
+	err error
+}
+
+func (e *encoder) uint8(v uint8) {
+	e.b = append(e.b, v)
+}
+
+func (e *encoder) uint16(v uint16) {
+	e.b = binary.BigEndian.AppendUint16(e.b, v)
+}
+
+func (e *encoder) uint32(v uint32) {
+	e.b = binary.BigEndian.AppendUint32(e.b, v)
+}
+
+func (e *encoder) uint64(v uint64) {
+	e.b = binary.BigEndian.AppendUint64(e.b, v)
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.b = append(e.b, b...)
+}
+
+func (e *encoder) string(s string) {
+	if len(s) > 255 {
+		if e.err == nil {
+			e.err = &EncodeError{Type: e.typ, Reason: fmt.Sprintf("string of %d bytes is longer than 255 bytes", len(s))}
+		}
+		return
+	}
+	e.uint8(uint8(len(s)))
+	e.b = append(e.b, s...)
+}
+
+// mapping writes m sorted by key, as required for signed mappings.
+func (e *encoder) mapping(m map[string]string) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	inner := encoder{typ: e.typ}
+	for _, k := range keys {
+		inner.string(k)
+		inner.uint8('=')
+		inner.string(m[k])
+		inner.uint8(';')
+	}
+	if inner.err != nil {
+		if e.err == nil {
+			e.err = inner.err
+		}
+		return
+	}
+	if len(inner.b) > 0xffff {
+		if e.err == nil {
+			e.err = &EncodeError{Type: e.typ, Reason: "mapping is larger than 65535 bytes"}
+		}
+		return
+	}
+	e.uint16(uint16(len(inner.b)))
+	e.bytes(inner.b)
+}
+
+func (e *encoder) destination(b []byte) {
+	if len(b) < DestinationKeysSize+3 {
+		if e.err == nil {
+			e.err = &EncodeError{Type: e.typ, Reason: fmt.Sprintf("destination of %d bytes is too short", len(b))}
+		}
+		return
+	}
+	e.bytes(b)
+}
+
+func (e *encoder) result() ([]byte, error) {
+	if e.err != nil {
+		return nil, e.err
+	}
+	return e.b, nil
+}
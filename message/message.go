@@ -0,0 +1,199 @@
+// Package message encodes and decodes I2CP messages.
+//
+// Every message type has a struct with Marshal and Unmarshal methods working
+// on the message body, Read and Write add the 5 byte frame header. The
+// package only depends on the standard library, so clients and router
+// stand-ins can share it.
+package message
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+)
+
+// Message types as defined by the I2CP specification.
+const (
+	TypeCreateSession           uint8 = 1
+	TypeReconfigureSession      uint8 = 2
+	TypeDestroySession          uint8 = 3
+	TypeCreateLeaseSet          uint8 = 4
+	TypeSendMessage             uint8 = 5
+	TypeReceiveMessageBegin     uint8 = 6
+	TypeReceiveMessageEnd       uint8 = 7
+	TypeGetBandwidthLimits      uint8 = 8
+	TypeSessionStatus           uint8 = 20
+	TypeRequestLeaseSet         uint8 = 21
+	TypeMessageStatus           uint8 = 22
+	TypeBandwidthLimits         uint8 = 23
+	TypeReportAbuse             uint8 = 29
+	TypeDisconnect              uint8 = 30
+	TypeMessagePayload          uint8 = 31
+	TypeGetDate                 uint8 = 32
+	TypeSetDate                 uint8 = 33
+	TypeDestLookup              uint8 = 34
+	TypeDestReply               uint8 = 35
+	TypeSendMessageExpires      uint8 = 36
+	TypeRequestVariableLeaseSet uint8 = 37
+	TypeHostLookup              uint8 = 38
+	TypeHostReply               uint8 = 39
+)
+
+// HeaderSize is the size of the frame header, a 4 byte body length followed
+// by the message type.
+const HeaderSize = 5
+
+// MaxSize is the largest message body Read accepts by default.
+const MaxSize = 0xffff
+
+// DestinationKeysSize is the size of the public key and signing public key
+// at the start of every destination.
+const DestinationKeysSize = 384
+
+var (
+	// ErrUnknownType is returned for message types without a registered codec.
+	ErrUnknownType = errors.New("message: unknown message type")
+	// ErrMalformed matches every *DecodeError.
+	ErrMalformed = errors.New("message: malformed message")
+	// ErrTooLarge is returned by Read for messages larger than the limit.
+	ErrTooLarge = errors.New("message: message too large")
+)
+
+// DecodeError is returned when a message body can't be parsed.
+type DecodeError struct {
+	Type   uint8
+	Reason string
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("message: malformed message type %d: %s", e.Type, e.Reason)
+}
+
+func (e *DecodeError) Is(target error) bool {
+	return target == ErrMalformed
+}
+
+// EncodeError is returned when a message can't be represented on the wire.
+type EncodeError struct {
+	Type   uint8
+	Reason string
+}
+
+func (e *EncodeError) Error() string {
+	return fmt.Sprintf("message: can't encode message type %d: %s", e.Type, e.Reason)
+}
+
+// Message is a single I2CP message.
+type Message interface {
+	// Type returns the message type byte.
+	Type() uint8
+	// Marshal returns the message body.
+	Marshal() ([]byte, error)
+	// Unmarshal parses the message body.
+	Unmarshal(body []byte) error
+}
+
+// Hash is a SHA-256 hash, as used for destination lookups.
+type Hash [32]byte
+
+var registry = map[uint8]func() Message{}
+
+// Register adds the constructor for a message type, it replaces a codec
+// registered earlier for the same type.
+func Register(typ uint8, f func() Message) {
+	registry[typ] = f
+}
+
+// New returns an empty message of type typ.
+func New(typ uint8) (Message, error) {
+	f, ok := registry[typ]
+	if !ok {
+		return nil, fmt.Errorf("%w %d", ErrUnknownType, typ)
+	}
+	return f(), nil
+}
+
+// Types returns the registered message types in ascending order.
+func Types() []uint8 {
+	types := make([]uint8, 0, len(registry))
+	for typ := range registry {
+		types = append(types, typ)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+// Name returns the name of the struct for message type typ, e.g.
+// CreateSession, or Unknown(typ) for types without a registered codec.
+func Name(typ uint8) string {
+	m, err := New(typ)
+	if err != nil {
+		return fmt.Sprintf("Unknown(%d)", typ)
+	}
+	return reflect.TypeOf(m).Elem().Name()
+}
+
+// Decode parses body as a message of type typ.
+func Decode(typ uint8, body []byte) (Message, error) {
+	m, err := New(typ)
+	if err != nil {
+		return nil, err
+	}
+	if err = m.Unmarshal(body); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Encode returns m with its frame header.
+func Encode(m Message) ([]byte, error) {
+	body, err := m.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	frame := make([]byte, HeaderSize, HeaderSize+len(body))
+	binary.BigEndian.PutUint32(frame, uint32(len(body)))
+	frame[4] = m.Type()
+	return append(frame, body...), nil
+}
+
+// Write writes m with its frame header to w.
+func Write(w io.Writer, m Message) error {
+	frame, err := Encode(m)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(frame)
+	return err
+}
+
+// ReadFrame reads one frame from r and returns its type and body without
+// decoding it. Bodies larger than maxSize are rejected with ErrTooLarge.
+func ReadFrame(r io.Reader, maxSize uint32) (typ uint8, body []byte, err error) {
+	var header [HeaderSize]byte
+	if _, err = io.ReadFull(r, header[:]); err != nil {
+		return
+	}
+	length := binary.BigEndian.Uint32(header[:4])
+	typ = header[4]
+	if length > maxSize {
+		return typ, nil, fmt.Errorf("%w: type %d has %d bytes, limit is %d", ErrTooLarge, typ, length, maxSize)
+	}
+	body = make([]byte, length)
+	if _, err = io.ReadFull(r, body); err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return
+}
+
+// Read reads and decodes one message from r.
+func Read(r io.Reader) (Message, error) {
+	typ, body, err := ReadFrame(r, MaxSize)
+	if err != nil {
+		return nil, err
+	}
+	return Decode(typ, body)
+}
@@ -0,0 +1,223 @@
+package message
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"testing/iotest"
+)
+
+func testDestination(certificate ...byte) []byte {
+	destination := make([]byte, DestinationKeysSize+3, DestinationKeysSize+3+len(certificate))
+	for i := range destination[:DestinationKeysSize] {
+		destination[i] = byte(i)
+	}
+	if len(certificate) > 0 {
+		destination[DestinationKeysSize] = 5
+		destination[DestinationKeysSize+2] = byte(len(certificate))
+	}
+	return append(destination, certificate...)
+}
+
+func testMessages() []Message {
+	hash := Hash{1, 2, 3, 4}
+	config := SessionConfig{
+		Destination: testDestination(0, 7, 0, 0),
+		Options:     map[string]string{"inbound.quantity": "3", "outbound.nickname": "test"},
+		Date:        1500000000000,
+		Signature:   bytes.Repeat([]byte{0xaa}, 40),
+	}
+	return []Message{
+		&CreateSession{config},
+		&ReconfigureSession{SessionId: 3, SessionConfig: config},
+		&DestroySession{SessionId: 3},
+		&CreateLeaseSet{SessionId: 3, SigningPrivateKey: make([]byte, SigningPrivateKeySize), PrivateKey: make([]byte, PrivateKeySize), LeaseSet: []byte{1, 2, 3}},
+		&SendMessage{SessionId: 3, Destination: testDestination(), Payload: []byte("payload"), Nonce: 9},
+		&ReceiveMessageBegin{SessionId: 3, MessageId: 11},
+		&ReceiveMessageEnd{SessionId: 3, MessageId: 11},
+		&GetBandwidthLimits{},
+		&SessionStatus{SessionId: 3, Status: SessionCreated},
+		&RequestLeaseSet{SessionId: 3, Tunnels: []TunnelGateway{{hash, 1}, {hash, 2}}, EndDate: 1500000000000},
+		&MessageStatus{SessionId: 3, MessageId: 11, Status: 4, Size: 100, Nonce: 9},
+		&BandwidthLimits{ClientInbound: 1, ClientOutbound: 2, RouterInbound: 3, RouterInboundBurst: 4, RouterOutbound: 5, RouterOutboundBurst: 6, RouterBurstTime: 7, Undefined: [9]uint32{8}},
+		&ReportAbuse{SessionId: 3, Severity: 100, Reason: "spam", MessageId: 11},
+		&Disconnect{Reason: "shutting down"},
+		&MessagePayload{SessionId: 3, MessageId: 11, Payload: []byte("payload")},
+		&GetDate{Version: "0.9.33", Options: map[string]string{"i2cp.username": "user", "i2cp.password": "secret"}},
+		&SetDate{Date: 1500000000000, Version: "0.9.33"},
+		&DestLookup{Hash: hash},
+		&DestReply{Destination: testDestination()},
+		&SendMessageExpires{SendMessage: SendMessage{SessionId: 3, Destination: testDestination(), Payload: []byte("payload"), Nonce: 9}, Flags: 0x0100, Expiration: 1500000000000},
+		&RequestVariableLeaseSet{SessionId: 3, Leases: []Lease{{hash, 1, 1500000000000}, {hash, 2, 1500000000001}}},
+		&HostLookup{SessionId: 3, RequestId: 12, Timeout: 30000, LookupType: LookupHost, Host: "example.i2p"},
+		&HostReply{SessionId: 3, RequestId: 12, Destination: testDestination()},
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	covered := make(map[uint8]bool)
+	for _, m := range testMessages() {
+		covered[m.Type()] = true
+		var buf bytes.Buffer
+		if err := Write(&buf, m); err != nil {
+			t.Fatalf("Could not write message type %d: %s", m.Type(), err.Error())
+		}
+		decoded, err := Read(&buf)
+		if err != nil {
+			t.Fatalf("Could not read message type %d: %s", m.Type(), err.Error())
+		}
+		if !reflect.DeepEqual(m, decoded) {
+			t.Fatalf("Message type %d changed in a round trip:\n%+v\n%+v", m.Type(), m, decoded)
+		}
+		if buf.Len() != 0 {
+			t.Fatalf("Message type %d left %d bytes unread", m.Type(), buf.Len())
+		}
+	}
+	for _, typ := range Types() {
+		if !covered[typ] {
+			t.Errorf("No round trip test for message type %d", typ)
+		}
+	}
+}
+
+func TestRoundTrip_Variants(t *testing.T) {
+	for _, m := range []Message{
+		&GetDate{Version: "0.9.33"},
+		&SetDate{Date: 1500000000000},
+		&DestReply{Hash: Hash{1, 2, 3}},
+		&HostLookup{SessionId: 3, RequestId: 12, Timeout: 30000, LookupType: LookupHash, Hash: Hash{1, 2, 3}},
+		&HostReply{SessionId: 3, RequestId: 12, Result: 1},
+	} {
+		body, err := m.Marshal()
+		if err != nil {
+			t.Fatalf("Could not marshal message type %d: %s", m.Type(), err.Error())
+		}
+		decoded, err := Decode(m.Type(), body)
+		if err != nil {
+			t.Fatalf("Could not decode message type %d: %s", m.Type(), err.Error())
+		}
+		if !reflect.DeepEqual(m, decoded) {
+			t.Fatalf("Message type %d changed in a round trip:\n%+v\n%+v", m.Type(), m, decoded)
+		}
+	}
+}
+
+func TestDecode_Truncated(t *testing.T) {
+	for _, m := range []Message{
+		&SessionStatus{SessionId: 3, Status: SessionCreated},
+		&MessagePayload{SessionId: 3, MessageId: 11, Payload: []byte("payload")},
+		&RequestVariableLeaseSet{SessionId: 3, Leases: []Lease{{Hash{1}, 1, 2}}},
+		&CreateSession{SessionConfig{Destination: testDestination(), Options: map[string]string{"a": "b"}, Date: 1}},
+	} {
+		body, _ := m.Marshal()
+		if _, err := Decode(m.Type(), body[:len(body)-1]); !errors.Is(err, ErrMalformed) {
+			t.Fatalf("Expected a malformed error for truncated message type %d, got %v", m.Type(), err)
+		}
+		if _, err := Decode(m.Type(), body[:1]); !errors.Is(err, ErrMalformed) {
+			t.Fatalf("Expected a malformed error for truncated message type %d, got %v", m.Type(), err)
+		}
+	}
+}
+
+func TestDecode_UnknownType(t *testing.T) {
+	if _, err := Decode(200, nil); !errors.Is(err, ErrUnknownType) {
+		t.Fatalf("Expected ErrUnknownType, got %v", err)
+	}
+}
+
+func TestReadFrame_TooLarge(t *testing.T) {
+	frame := []byte{0, 1, 0, 0, TypeDisconnect}
+	if _, _, err := ReadFrame(bytes.NewReader(frame), MaxSize); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("Expected ErrTooLarge, got %v", err)
+	}
+}
+
+func TestReadFrame_ByteByByte(t *testing.T) {
+	frame, err := Encode(&SetDate{Date: 1500000000000, Version: "0.9.33"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	typ, body, err := ReadFrame(iotest.OneByteReader(bytes.NewReader(frame)), MaxSize)
+	if err != nil {
+		t.Fatalf("Could not read frame: %s", err.Error())
+	}
+	if typ != TypeSetDate || !bytes.Equal(body, frame[HeaderSize:]) {
+		t.Fatalf("Unexpected type %d or body %x", typ, body)
+	}
+}
+
+func TestReadFrame_MultipleFrames(t *testing.T) {
+	var input bytes.Buffer
+	messages := []Message{&SessionStatus{SessionId: 1, Status: SessionCreated}, &GetBandwidthLimits{},
+		&MessagePayload{Payload: bytes.Repeat([]byte{0xaa}, 4096)}}
+	for _, m := range messages {
+		if err := Write(&input, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := iotest.HalfReader(&input)
+	var previous []byte
+	for i, m := range messages {
+		typ, body, err := ReadFrame(r, MaxSize)
+		if err != nil {
+			t.Fatalf("Could not read frame %d: %s", i, err.Error())
+		}
+		if typ != m.Type() {
+			t.Fatalf("Frame %d: expected type %d, got %d", i, m.Type(), typ)
+		}
+		if len(previous) > 0 && len(body) > 0 && &previous[0] == &body[0] {
+			t.Fatal("Frames share a buffer")
+		}
+		previous = body
+	}
+	if _, _, err := ReadFrame(r, MaxSize); err != io.EOF {
+		t.Fatalf("Expected io.EOF after the last frame, got %v", err)
+	}
+}
+
+func TestReadFrame_Truncated(t *testing.T) {
+	frame, err := Encode(&MessagePayload{Payload: make([]byte, 128)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = ReadFrame(bytes.NewReader(frame[:64]), MaxSize); err != io.ErrUnexpectedEOF {
+		t.Fatalf("Expected io.ErrUnexpectedEOF, got %v", err)
+	}
+}
+
+func TestName(t *testing.T) {
+	if name := Name(TypeCreateSession); name != "CreateSession" {
+		t.Fatalf("Expected CreateSession, got %s", name)
+	}
+	if name := Name(200); name != "Unknown(200)" {
+		t.Fatalf("Expected Unknown(200), got %s", name)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	for _, m := range testMessages() {
+		body, err := m.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		redacted := Redact(m.Type(), body)
+		if bytes.Contains(redacted, []byte("secret")) {
+			t.Fatalf("Password of message type %d was not redacted", m.Type())
+		}
+		if m.Type() != TypeGetDate {
+			if !bytes.Equal(redacted, body) {
+				t.Fatalf("Message type %d without password was changed", m.Type())
+			}
+			continue
+		}
+		getDate := &GetDate{}
+		if err = getDate.Unmarshal(redacted); err != nil {
+			t.Fatal(err)
+		}
+		if getDate.Options[PasswordOption] != Redacted || getDate.Options["i2cp.username"] != "user" {
+			t.Fatalf("Unexpected redacted options %v", getDate.Options)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package message
+
+// PasswordOption is the option of GetDate, CreateSession and
+// ReconfigureSession that carries the I2CP password.
+const PasswordOption = "i2cp.password"
+
+// Redacted replaces secrets in redacted messages.
+const Redacted = "<redacted>"
+
+// RedactOptions returns a copy of options with the password replaced by
+// Redacted, or options itself when it has no password.
+func RedactOptions(options map[string]string) map[string]string {
+	if _, ok := options[PasswordOption]; !ok {
+		return options
+	}
+	redacted := make(map[string]string, len(options))
+	for key, value := range options {
+		redacted[key] = value
+	}
+	redacted[PasswordOption] = Redacted
+	return redacted
+}
+
+// Redact returns body with the password option replaced by Redacted for the
+// message types that carry one. Other bodies, and bodies that can't be
+// parsed, are returned unchanged. The signature of a redacted session config
+// no longer matches.
+func Redact(typ uint8, body []byte) []byte {
+	var options *map[string]string
+	m, err := Decode(typ, body)
+	if err != nil {
+		return body
+	}
+	switch m := m.(type) {
+	case *GetDate:
+		options = &m.Options
+	case *CreateSession:
+		options = &m.Options
+	case *ReconfigureSession:
+		options = &m.Options
+	default:
+		return body
+	}
+	if _, ok := (*options)[PasswordOption]; !ok {
+		return body
+	}
+	*options = RedactOptions(*options)
+	redacted, err := m.Marshal()
+	if err != nil {
+		return body
+	}
+	return redacted
+}
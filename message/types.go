@@ -0,0 +1,750 @@
+package message
+
+import "fmt"
+
+// Session status values of SessionStatus.
+const (
+	SessionDestroyed uint8 = iota
+	SessionCreated
+	SessionUpdated
+	SessionInvalid
+	SessionRefused
+)
+
+// Lookup types of HostLookup.
+const (
+	LookupHash uint8 = 0
+	LookupHost uint8 = 1
+)
+
+// Key sizes CreateLeaseSet assumes when decoding, the only signature type
+// the I2CP lease set of CreateLeaseSet is used with is DSA-SHA1.
+const (
+	SigningPrivateKeySize = 20
+	PrivateKeySize        = 256
+)
+
+// MaxLeases is the most leases a lease set can hold.
+const MaxLeases = 16
+
+func init() {
+	Register(TypeCreateSession, func() Message { return new(CreateSession) })
+	Register(TypeReconfigureSession, func() Message { return new(ReconfigureSession) })
+	Register(TypeDestroySession, func() Message { return new(DestroySession) })
+	Register(TypeCreateLeaseSet, func() Message { return new(CreateLeaseSet) })
+	Register(TypeSendMessage, func() Message { return new(SendMessage) })
+	Register(TypeReceiveMessageBegin, func() Message { return new(ReceiveMessageBegin) })
+	Register(TypeReceiveMessageEnd, func() Message { return new(ReceiveMessageEnd) })
+	Register(TypeGetBandwidthLimits, func() Message { return new(GetBandwidthLimits) })
+	Register(TypeSessionStatus, func() Message { return new(SessionStatus) })
+	Register(TypeRequestLeaseSet, func() Message { return new(RequestLeaseSet) })
+	Register(TypeMessageStatus, func() Message { return new(MessageStatus) })
+	Register(TypeBandwidthLimits, func() Message { return new(BandwidthLimits) })
+	Register(TypeReportAbuse, func() Message { return new(ReportAbuse) })
+	Register(TypeDisconnect, func() Message { return new(Disconnect) })
+	Register(TypeMessagePayload, func() Message { return new(MessagePayload) })
+	Register(TypeGetDate, func() Message { return new(GetDate) })
+	Register(TypeSetDate, func() Message { return new(SetDate) })
+	Register(TypeDestLookup, func() Message { return new(DestLookup) })
+	Register(TypeDestReply, func() Message { return new(DestReply) })
+	Register(TypeSendMessageExpires, func() Message { return new(SendMessageExpires) })
+	Register(TypeRequestVariableLeaseSet, func() Message { return new(RequestVariableLeaseSet) })
+	Register(TypeHostLookup, func() Message { return new(HostLookup) })
+	Register(TypeHostReply, func() Message { return new(HostReply) })
+}
+
+// SessionConfig is the signed session configuration of CreateSession and
+// ReconfigureSession. Destination holds the destination's wire bytes, Date
+// is in milliseconds since the epoch and Signature runs to the end of the
+// message.
+type SessionConfig struct {
+	Destination []byte
+	Options     map[string]string
+	Date        uint64
+	Signature   []byte
+}
+
+func (c *SessionConfig) encode(e *encoder) {
+	e.destination(c.Destination)
+	e.mapping(c.Options)
+	e.uint64(c.Date)
+	e.bytes(c.Signature)
+}
+
+func (c *SessionConfig) decode(d *decoder) {
+	c.Destination = d.destination("destination")
+	c.Options = d.mapping("options")
+	c.Date = d.uint64("date")
+	c.Signature = d.rest()
+}
+
+// CreateSession asks the router to create a session, client to router.
+type CreateSession struct {
+	SessionConfig
+}
+
+func (m *CreateSession) Type() uint8 { return TypeCreateSession }
+
+func (m *CreateSession) Marshal() ([]byte, error) {
+	e := encoder{typ: m.Type()}
+	m.encode(&e)
+	return e.result()
+}
+
+func (m *CreateSession) Unmarshal(body []byte) error {
+	d := decoder{typ: m.Type(), b: body}
+	m.decode(&d)
+	return d.err
+}
+
+// ReconfigureSession updates the options of a session, client to router.
+type ReconfigureSession struct {
+	SessionId uint16
+	SessionConfig
+}
+
+func (m *ReconfigureSession) Type() uint8 { return TypeReconfigureSession }
+
+func (m *ReconfigureSession) Marshal() ([]byte, error) {
+	e := encoder{typ: m.Type()}
+	e.uint16(m.SessionId)
+	m.encode(&e)
+	return e.result()
+}
+
+func (m *ReconfigureSession) Unmarshal(body []byte) error {
+	d := decoder{typ: m.Type(), b: body}
+	m.SessionId = d.uint16("session id")
+	m.decode(&d)
+	return d.err
+}
+
+// DestroySession ends a session, client to router.
+type DestroySession struct {
+	SessionId uint16
+}
+
+func (m *DestroySession) Type() uint8 { return TypeDestroySession }
+
+func (m *DestroySession) Marshal() ([]byte, error) {
+	e := encoder{typ: m.Type()}
+	e.uint16(m.SessionId)
+	return e.result()
+}
+
+func (m *DestroySession) Unmarshal(body []byte) error {
+	d := decoder{typ: m.Type(), b: body}
+	m.SessionId = d.uint16("session id")
+	return d.err
+}
+
+// CreateLeaseSet publishes the signed lease set of a session, client to
+// router. LeaseSet holds the lease set's wire bytes.
+type CreateLeaseSet struct {
+	SessionId         uint16
+	SigningPrivateKey []byte
+	PrivateKey        []byte
+	LeaseSet          []byte
+}
+
+func (m *CreateLeaseSet) Type() uint8 { return TypeCreateLeaseSet }
+
+func (m *CreateLeaseSet) Marshal() ([]byte, error) {
+	e := encoder{typ: m.Type()}
+	e.uint16(m.SessionId)
+	e.bytes(m.SigningPrivateKey)
+	e.bytes(m.PrivateKey)
+	e.bytes(m.LeaseSet)
+	return e.result()
+}
+
+func (m *CreateLeaseSet) Unmarshal(body []byte) error {
+	d := decoder{typ: m.Type(), b: body}
+	m.SessionId = d.uint16("session id")
+	m.SigningPrivateKey = d.bytes(SigningPrivateKeySize, "signing private key")
+	m.PrivateKey = d.bytes(PrivateKeySize, "private key")
+	m.LeaseSet = d.rest()
+	return d.err
+}
+
+// SendMessage sends Payload, a gzip compressed I2CP payload, to Destination,
+// client to router. The router reports the outcome with MessageStatus unless
+// Nonce is 0.
+type SendMessage struct {
+	SessionId   uint16
+	Destination []byte
+	Payload     []byte
+	Nonce       uint32
+}
+
+func (m *SendMessage) Type() uint8 { return TypeSendMessage }
+
+func (m *SendMessage) Marshal() ([]byte, error) {
+	e := encoder{typ: m.Type()}
+	e.uint16(m.SessionId)
+	e.destination(m.Destination)
+	e.uint32(uint32(len(m.Payload)))
+	e.bytes(m.Payload)
+	e.uint32(m.Nonce)
+	return e.result()
+}
+
+func (m *SendMessage) Unmarshal(body []byte) error {
+	d := decoder{typ: m.Type(), b: body}
+	m.SessionId = d.uint16("session id")
+	m.Destination = d.destination("destination")
+	m.Payload = d.bytes(int(d.uint32("payload size")), "payload")
+	m.Nonce = d.uint32("nonce")
+	return d.err
+}
+
+// ReceiveMessageBegin asks the router for a message, client to router. It is
+// only used without i2cp.fastReceive.
+type ReceiveMessageBegin struct {
+	SessionId uint16
+	MessageId uint32
+}
+
+func (m *ReceiveMessageBegin) Type() uint8 { return TypeReceiveMessageBegin }
+
+func (m *ReceiveMessageBegin) Marshal() ([]byte, error) {
+	e := encoder{typ: m.Type()}
+	e.uint16(m.SessionId)
+	e.uint32(m.MessageId)
+	return e.result()
+}
+
+func (m *ReceiveMessageBegin) Unmarshal(body []byte) error {
+	d := decoder{typ: m.Type(), b: body}
+	m.SessionId = d.uint16("session id")
+	m.MessageId = d.uint32("message id")
+	return d.err
+}
+
+// ReceiveMessageEnd tells the router a message was received, client to
+// router. It is only used without i2cp.fastReceive.
+type ReceiveMessageEnd struct {
+	SessionId uint16
+	MessageId uint32
+}
+
+func (m *ReceiveMessageEnd) Type() uint8 { return TypeReceiveMessageEnd }
+
+func (m *ReceiveMessageEnd) Marshal() ([]byte, error) {
+	e := encoder{typ: m.Type()}
+	e.uint16(m.SessionId)
+	e.uint32(m.MessageId)
+	return e.result()
+}
+
+func (m *ReceiveMessageEnd) Unmarshal(body []byte) error {
+	d := decoder{typ: m.Type(), b: body}
+	m.SessionId = d.uint16("session id")
+	m.MessageId = d.uint32("message id")
+	return d.err
+}
+
+// GetBandwidthLimits asks the router for its bandwidth limits, client to
+// router.
+type GetBandwidthLimits struct{}
+
+func (m *GetBandwidthLimits) Type() uint8 { return TypeGetBandwidthLimits }
+
+func (m *GetBandwidthLimits) Marshal() ([]byte, error) { return []byte{}, nil }
+
+func (m *GetBandwidthLimits) Unmarshal(body []byte) error { return nil }
+
+// SessionStatus reports the state of a session, router to client.
+type SessionStatus struct {
+	SessionId uint16
+	Status    uint8
+}
+
+func (m *SessionStatus) Type() uint8 { return TypeSessionStatus }
+
+func (m *SessionStatus) Marshal() ([]byte, error) {
+	e := encoder{typ: m.Type()}
+	e.uint16(m.SessionId)
+	e.uint8(m.Status)
+	return e.result()
+}
+
+func (m *SessionStatus) Unmarshal(body []byte) error {
+	d := decoder{typ: m.Type(), b: body}
+	m.SessionId = d.uint16("session id")
+	m.Status = d.uint8("status")
+	return d.err
+}
+
+// TunnelGateway is a tunnel of RequestLeaseSet, Router is the hash of the
+// gateway's router identity.
+type TunnelGateway struct {
+	Router   Hash
+	TunnelId uint32
+}
+
+// RequestLeaseSet asks the client to sign a lease set for the given inbound
+// tunnels, router to client. Routers since 0.9.7 send RequestVariableLeaseSet.
+type RequestLeaseSet struct {
+	SessionId uint16
+	Tunnels   []TunnelGateway
+	EndDate   uint64
+}
+
+func (m *RequestLeaseSet) Type() uint8 { return TypeRequestLeaseSet }
+
+func (m *RequestLeaseSet) Marshal() ([]byte, error) {
+	if len(m.Tunnels) > MaxLeases {
+		return nil, &EncodeError{Type: m.Type(), Reason: fmt.Sprintf("%d tunnels, at most %d are allowed", len(m.Tunnels), MaxLeases)}
+	}
+	e := encoder{typ: m.Type()}
+	e.uint16(m.SessionId)
+	e.uint8(uint8(len(m.Tunnels)))
+	for _, tunnel := range m.Tunnels {
+		e.bytes(tunnel.Router[:])
+		e.uint32(tunnel.TunnelId)
+	}
+	e.uint64(m.EndDate)
+	return e.result()
+}
+
+func (m *RequestLeaseSet) Unmarshal(body []byte) error {
+	d := decoder{typ: m.Type(), b: body}
+	m.SessionId = d.uint16("session id")
+	n := d.uint8("number of tunnels")
+	m.Tunnels = nil
+	for i := uint8(0); i < n && d.err == nil; i++ {
+		var tunnel TunnelGateway
+		tunnel.Router = d.hash("tunnel gateway")
+		tunnel.TunnelId = d.uint32("tunnel id")
+		m.Tunnels = append(m.Tunnels, tunnel)
+	}
+	m.EndDate = d.uint64("end date")
+	return d.err
+}
+
+// MessageStatus reports the delivery state of a sent message, router to
+// client.
+type MessageStatus struct {
+	SessionId uint16
+	MessageId uint32
+	Status    uint8
+	Size      uint32
+	Nonce     uint32
+}
+
+func (m *MessageStatus) Type() uint8 { return TypeMessageStatus }
+
+func (m *MessageStatus) Marshal() ([]byte, error) {
+	e := encoder{typ: m.Type()}
+	e.uint16(m.SessionId)
+	e.uint32(m.MessageId)
+	e.uint8(m.Status)
+	e.uint32(m.Size)
+	e.uint32(m.Nonce)
+	return e.result()
+}
+
+func (m *MessageStatus) Unmarshal(body []byte) error {
+	d := decoder{typ: m.Type(), b: body}
+	m.SessionId = d.uint16("session id")
+	m.MessageId = d.uint32("message id")
+	m.Status = d.uint8("status")
+	m.Size = d.uint32("size")
+	m.Nonce = d.uint32("nonce")
+	return d.err
+}
+
+// BandwidthLimits answers GetBandwidthLimits, router to client. The limits
+// are in KBytes per second, burst time in seconds.
+type BandwidthLimits struct {
+	ClientInbound       uint32
+	ClientOutbound      uint32
+	RouterInbound       uint32
+	RouterInboundBurst  uint32
+	RouterOutbound      uint32
+	RouterOutboundBurst uint32
+	RouterBurstTime     uint32
+	Undefined           [9]uint32
+}
+
+func (m *BandwidthLimits) Type() uint8 { return TypeBandwidthLimits }
+
+func (m *BandwidthLimits) fields() []*uint32 {
+	fields := []*uint32{&m.ClientInbound, &m.ClientOutbound, &m.RouterInbound, &m.RouterInboundBurst,
+		&m.RouterOutbound, &m.RouterOutboundBurst, &m.RouterBurstTime}
+	for i := range m.Undefined {
+		fields = append(fields, &m.Undefined[i])
+	}
+	return fields
+}
+
+func (m *BandwidthLimits) Marshal() ([]byte, error) {
+	e := encoder{typ: m.Type()}
+	for _, field := range m.fields() {
+		e.uint32(*field)
+	}
+	return e.result()
+}
+
+func (m *BandwidthLimits) Unmarshal(body []byte) error {
+	d := decoder{typ: m.Type(), b: body}
+	for _, field := range m.fields() {
+		*field = d.uint32("limit")
+	}
+	return d.err
+}
+
+// ReportAbuse reports abuse of a session, in either direction. It is unused
+// by current routers.
+type ReportAbuse struct {
+	SessionId uint16
+	Severity  uint8
+	Reason    string
+	MessageId uint32
+}
+
+func (m *ReportAbuse) Type() uint8 { return TypeReportAbuse }
+
+func (m *ReportAbuse) Marshal() ([]byte, error) {
+	e := encoder{typ: m.Type()}
+	e.uint16(m.SessionId)
+	e.uint8(m.Severity)
+	e.string(m.Reason)
+	e.uint32(m.MessageId)
+	return e.result()
+}
+
+func (m *ReportAbuse) Unmarshal(body []byte) error {
+	d := decoder{typ: m.Type(), b: body}
+	m.SessionId = d.uint16("session id")
+	m.Severity = d.uint8("severity")
+	m.Reason = d.string("reason")
+	m.MessageId = d.uint32("message id")
+	return d.err
+}
+
+// Disconnect ends the connection, in either direction.
+type Disconnect struct {
+	Reason string
+}
+
+func (m *Disconnect) Type() uint8 { return TypeDisconnect }
+
+func (m *Disconnect) Marshal() ([]byte, error) {
+	e := encoder{typ: m.Type()}
+	e.string(m.Reason)
+	return e.result()
+}
+
+func (m *Disconnect) Unmarshal(body []byte) error {
+	d := decoder{typ: m.Type(), b: body}
+	m.Reason = d.string("reason")
+	return d.err
+}
+
+// MessagePayload delivers a received message, router to client. Payload is
+// the gzip compressed I2CP payload.
+type MessagePayload struct {
+	SessionId uint16
+	MessageId uint32
+	Payload   []byte
+}
+
+func (m *MessagePayload) Type() uint8 { return TypeMessagePayload }
+
+func (m *MessagePayload) Marshal() ([]byte, error) {
+	e := encoder{typ: m.Type()}
+	e.uint16(m.SessionId)
+	e.uint32(m.MessageId)
+	e.uint32(uint32(len(m.Payload)))
+	e.bytes(m.Payload)
+	return e.result()
+}
+
+func (m *MessagePayload) Unmarshal(body []byte) error {
+	d := decoder{typ: m.Type(), b: body}
+	m.SessionId = d.uint16("session id")
+	m.MessageId = d.uint32("message id")
+	m.Payload = d.bytes(int(d.uint32("payload size")), "payload")
+	return d.err
+}
+
+// GetDate starts the handshake, client to router. Options carries
+// i2cp.username and i2cp.password when the router requires authentication,
+// it is left out when empty.
+type GetDate struct {
+	Version string
+	Options map[string]string
+}
+
+func (m *GetDate) Type() uint8 { return TypeGetDate }
+
+func (m *GetDate) Marshal() ([]byte, error) {
+	e := encoder{typ: m.Type()}
+	e.string(m.Version)
+	if len(m.Options) > 0 {
+		e.mapping(m.Options)
+	}
+	return e.result()
+}
+
+func (m *GetDate) Unmarshal(body []byte) error {
+	d := decoder{typ: m.Type(), b: body}
+	m.Version = d.string("version")
+	m.Options = nil
+	if d.err == nil && len(d.b) > 0 {
+		m.Options = d.mapping("options")
+	}
+	return d.err
+}
+
+// SetDate answers GetDate with the router's clock in milliseconds since the
+// epoch and its version, router to client.
+type SetDate struct {
+	Date    uint64
+	Version string
+}
+
+func (m *SetDate) Type() uint8 { return TypeSetDate }
+
+func (m *SetDate) Marshal() ([]byte, error) {
+	e := encoder{typ: m.Type()}
+	e.uint64(m.Date)
+	e.string(m.Version)
+	return e.result()
+}
+
+func (m *SetDate) Unmarshal(body []byte) error {
+	d := decoder{typ: m.Type(), b: body}
+	m.Date = d.uint64("date")
+	m.Version = ""
+	// routers before 0.8.7 don't send their version
+	if d.err == nil && len(d.b) > 0 {
+		m.Version = d.string("version")
+	}
+	return d.err
+}
+
+// DestLookup looks up the destination with the given hash, client to router.
+type DestLookup struct {
+	Hash Hash
+}
+
+func (m *DestLookup) Type() uint8 { return TypeDestLookup }
+
+func (m *DestLookup) Marshal() ([]byte, error) {
+	return append([]byte(nil), m.Hash[:]...), nil
+}
+
+func (m *DestLookup) Unmarshal(body []byte) error {
+	d := decoder{typ: m.Type(), b: body}
+	m.Hash = d.hash("hash")
+	return d.err
+}
+
+// DestReply answers DestLookup, router to client. A failed lookup has no
+// Destination and carries the hash that was looked up instead.
+type DestReply struct {
+	Destination []byte
+	Hash        Hash
+}
+
+func (m *DestReply) Type() uint8 { return TypeDestReply }
+
+func (m *DestReply) Marshal() ([]byte, error) {
+	if m.Destination != nil {
+		e := encoder{typ: m.Type()}
+		e.destination(m.Destination)
+		return e.result()
+	}
+	return append([]byte(nil), m.Hash[:]...), nil
+}
+
+func (m *DestReply) Unmarshal(body []byte) error {
+	d := decoder{typ: m.Type(), b: body}
+	m.Destination = nil
+	m.Hash = Hash{}
+	switch len(body) {
+	case 0:
+		// routers before 0.8.3 send an empty reply on failure
+	case len(m.Hash):
+		m.Hash = d.hash("hash")
+	default:
+		m.Destination = d.destination("destination")
+	}
+	return d.err
+}
+
+// SendMessageExpires is SendMessage with delivery flags and an expiration in
+// milliseconds since the epoch, client to router. The expiration is sent as
+// 6 bytes.
+type SendMessageExpires struct {
+	SendMessage
+	Flags      uint16
+	Expiration uint64
+}
+
+func (m *SendMessageExpires) Type() uint8 { return TypeSendMessageExpires }
+
+func (m *SendMessageExpires) Marshal() ([]byte, error) {
+	if m.Expiration >= 1<<48 {
+		return nil, &EncodeError{Type: m.Type(), Reason: "expiration doesn't fit in 6 bytes"}
+	}
+	body, err := m.SendMessage.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	e := encoder{typ: m.Type(), b: body}
+	e.uint64(uint64(m.Flags)<<48 | m.Expiration)
+	return e.result()
+}
+
+func (m *SendMessageExpires) Unmarshal(body []byte) error {
+	d := decoder{typ: m.Type(), b: body}
+	m.SessionId = d.uint16("session id")
+	m.Destination = d.destination("destination")
+	m.Payload = d.bytes(int(d.uint32("payload size")), "payload")
+	m.Nonce = d.uint32("nonce")
+	date := d.uint64("flags and expiration")
+	m.Flags = uint16(date >> 48)
+	m.Expiration = date & (1<<48 - 1)
+	return d.err
+}
+
+// Lease is a single lease of RequestVariableLeaseSet, EndDate is in
+// milliseconds since the epoch.
+type Lease struct {
+	Gateway  Hash
+	TunnelId uint32
+	EndDate  uint64
+}
+
+// RequestVariableLeaseSet asks the client to sign a lease set with the given
+// leases, router to client.
+type RequestVariableLeaseSet struct {
+	SessionId uint16
+	Leases    []Lease
+}
+
+func (m *RequestVariableLeaseSet) Type() uint8 { return TypeRequestVariableLeaseSet }
+
+func (m *RequestVariableLeaseSet) Marshal() ([]byte, error) {
+	if len(m.Leases) > MaxLeases {
+		return nil, &EncodeError{Type: m.Type(), Reason: fmt.Sprintf("%d leases, at most %d are allowed", len(m.Leases), MaxLeases)}
+	}
+	e := encoder{typ: m.Type()}
+	e.uint16(m.SessionId)
+	e.uint8(uint8(len(m.Leases)))
+	for _, lease := range m.Leases {
+		e.bytes(lease.Gateway[:])
+		e.uint32(lease.TunnelId)
+		e.uint64(lease.EndDate)
+	}
+	return e.result()
+}
+
+func (m *RequestVariableLeaseSet) Unmarshal(body []byte) error {
+	d := decoder{typ: m.Type(), b: body}
+	m.SessionId = d.uint16("session id")
+	n := d.uint8("number of leases")
+	if n > MaxLeases && d.err == nil {
+		return &DecodeError{Type: m.Type(), Reason: fmt.Sprintf("%d leases, at most %d are allowed", n, MaxLeases)}
+	}
+	m.Leases = nil
+	for i := uint8(0); i < n && d.err == nil; i++ {
+		var lease Lease
+		lease.Gateway = d.hash("lease gateway")
+		lease.TunnelId = d.uint32("lease tunnel id")
+		lease.EndDate = d.uint64("lease end date")
+		m.Leases = append(m.Leases, lease)
+	}
+	return d.err
+}
+
+// HostLookup looks up a destination by hash or host name, client to router.
+// Hash is used for LookupHash, Host for LookupHost. Timeout is in
+// milliseconds.
+type HostLookup struct {
+	SessionId  uint16
+	RequestId  uint32
+	Timeout    uint32
+	LookupType uint8
+	Hash       Hash
+	Host       string
+}
+
+func (m *HostLookup) Type() uint8 { return TypeHostLookup }
+
+func (m *HostLookup) Marshal() ([]byte, error) {
+	e := encoder{typ: m.Type()}
+	e.uint16(m.SessionId)
+	e.uint32(m.RequestId)
+	e.uint32(m.Timeout)
+	e.uint8(m.LookupType)
+	switch m.LookupType {
+	case LookupHash:
+		e.bytes(m.Hash[:])
+	case LookupHost:
+		e.string(m.Host)
+	default:
+		return nil, &EncodeError{Type: m.Type(), Reason: fmt.Sprintf("unsupported lookup type %d", m.LookupType)}
+	}
+	return e.result()
+}
+
+func (m *HostLookup) Unmarshal(body []byte) error {
+	d := decoder{typ: m.Type(), b: body}
+	m.SessionId = d.uint16("session id")
+	m.RequestId = d.uint32("request id")
+	m.Timeout = d.uint32("timeout")
+	m.LookupType = d.uint8("lookup type")
+	m.Hash = Hash{}
+	m.Host = ""
+	if d.err != nil {
+		return d.err
+	}
+	switch m.LookupType {
+	case LookupHash:
+		m.Hash = d.hash("hash")
+	case LookupHost:
+		m.Host = d.string("host name")
+	default:
+		return &DecodeError{Type: m.Type(), Reason: fmt.Sprintf("unsupported lookup type %d", m.LookupType)}
+	}
+	return d.err
+}
+
+// HostReply answers HostLookup, router to client. Result 0 means success and
+// comes with the Destination.
+type HostReply struct {
+	SessionId   uint16
+	RequestId   uint32
+	Result      uint8
+	Destination []byte
+}
+
+func (m *HostReply) Type() uint8 { return TypeHostReply }
+
+func (m *HostReply) Marshal() ([]byte, error) {
+	e := encoder{typ: m.Type()}
+	e.uint16(m.SessionId)
+	e.uint32(m.RequestId)
+	e.uint8(m.Result)
+	if m.Result == 0 {
+		e.destination(m.Destination)
+	}
+	return e.result()
+}
+
+func (m *HostReply) Unmarshal(body []byte) error {
+	d := decoder{typ: m.Type(), b: body}
+	m.SessionId = d.uint16("session id")
+	m.RequestId = d.uint32("request id")
+	m.Result = d.uint8("result")
+	m.Destination = nil
+	if m.Result == 0 && d.err == nil {
+		m.Destination = d.destination("destination")
+	}
+	return d.err
+}
@@ -0,0 +1,35 @@
+package go_i2cp
+
+import (
+	"errors"
+	"net"
+)
+
+// Pipe is a Transport over an already established net.Conn. It can't
+// reconnect, once closed Connect returns an error.
+type Pipe struct {
+	connTransport
+	used bool
+}
+
+// NewPipe returns a Transport backed by an in-memory net.Pipe together with
+// the router end of the pipe.
+func NewPipe() (*Pipe, net.Conn) {
+	client, router := net.Pipe()
+	return NewPipeFromConn(client), router
+}
+
+// NewPipeFromConn returns a Transport that talks I2CP over conn.
+func NewPipeFromConn(conn net.Conn) *Pipe {
+	p := &Pipe{}
+	p.setConn(conn)
+	return p
+}
+
+func (p *Pipe) Connect() error {
+	if p.used {
+		return errors.New("i2cp: a pipe transport can't be reconnected")
+	}
+	p.used = true
+	return nil
+}
@@ -0,0 +1,160 @@
+package go_i2cp
+
+import "context"
+
+// QueuePriority is the lane of the output queue a message is put in, higher
+// lanes are sent first.
+type QueuePriority int
+
+const (
+	// QUEUE_PRIORITY_BULK is used for SendMessage.
+	QUEUE_PRIORITY_BULK QueuePriority = iota
+	// QUEUE_PRIORITY_NORMAL is used for session creation, lookups and other
+	// requests.
+	QUEUE_PRIORITY_NORMAL
+	// QUEUE_PRIORITY_CONTROL is used for messages that keep the sessions
+	// alive or tear them down: CreateLeaseSet, DestroySession, Disconnect.
+	// This lane is not bounded, the protocol depends on these messages.
+	QUEUE_PRIORITY_CONTROL
+	NR_OF_QUEUE_PRIORITIES
+)
+
+// QueueConfig bounds the output queue. Set it with Client.SetQueueConfig.
+type QueueConfig struct {
+	// Capacity is the number of messages the bulk and normal lanes hold,
+	// at least 1. When the bulk lane is full Session.SendMessage fails with ErrQueueFull and
+	// Session.SendMessageContext waits for room.
+	Capacity int
+	// CoalesceSize is the number of bytes of queued frames written to the
+	// transport at once, a larger frame is written on its own.
+	CoalesceSize int
+}
+
+func DefaultQueueConfig() *QueueConfig {
+	return &QueueConfig{
+		Capacity:     256,
+		CoalesceSize: 16 * 1024,
+	}
+}
+
+// SetQueueConfig bounds the output queue with config, nil restores
+// DefaultQueueConfig. Fields that aren't positive are taken from
+// DefaultQueueConfig. Messages already queued are kept.
+func (c *Client) SetQueueConfig(config *QueueConfig) {
+	defaults := DefaultQueueConfig()
+	if config == nil {
+		config = defaults
+	}
+	queueConfig := *config
+	if queueConfig.Capacity < 1 {
+		queueConfig.Capacity = defaults.Capacity
+	}
+	if queueConfig.CoalesceSize < 1 {
+		queueConfig.CoalesceSize = defaults.CoalesceSize
+	}
+	c.lock.Lock()
+	c.queueConfig = queueConfig
+	c.lock.Unlock()
+}
+
+// messagePriority returns the lane for a message type.
+func messagePriority(typ uint8) QueuePriority {
+	switch typ {
+	case I2CP_MSG_SEND_MESSAGE, I2CP_MSG_SEND_MESSAGE_EXPIRES:
+		return QUEUE_PRIORITY_BULK
+	case I2CP_MSG_CREATE_LEASE_SET, I2CP_MSG_DESTROY_SESSION, I2CP_MSG_DISCONNECT, I2CP_MSG_RECONFIGURE_SESSION:
+		return QUEUE_PRIORITY_CONTROL
+	}
+	return QUEUE_PRIORITY_NORMAL
+}
+
+// sendQueue holds the frames waiting for the writer goroutine in one lane
+// per priority. It is guarded by the client lock.
+type sendQueue struct {
+	lanes [NR_OF_QUEUE_PRIORITIES][]*Stream
+	// space is closed and replaced whenever frames leave the queue
+	space chan struct{}
+}
+
+func (q *sendQueue) len() (n int) {
+	for _, lane := range q.lanes {
+		n += len(lane)
+	}
+	return
+}
+
+// take removes the frames for one write, highest priority first, up to max
+// bytes in total but always at least one frame.
+func (q *sendQueue) take(max int) (batch []*Stream, size int) {
+	for p := NR_OF_QUEUE_PRIORITIES - 1; p >= 0; p-- {
+		lane := q.lanes[p]
+		n := 0
+		for n < len(lane) && (len(batch) == 0 || size+lane[n].Len() <= max) {
+			size += lane[n].Len()
+			batch = append(batch, lane[n])
+			lane[n] = nil
+			n++
+		}
+		q.lanes[p] = lane[n:]
+		if n < len(lane) {
+			break
+		}
+	}
+	if len(batch) > 0 {
+		q.freed()
+	}
+	return
+}
+
+// reset drops every queued frame.
+func (q *sendQueue) reset() {
+	for p := range q.lanes {
+		q.lanes[p] = nil
+	}
+	q.freed()
+}
+
+// freed wakes up the callers waiting for room.
+func (q *sendQueue) freed() {
+	if q.space != nil {
+		close(q.space)
+		q.space = nil
+	}
+}
+
+// waitSpace returns a channel that is closed once frames left the queue.
+func (q *sendQueue) waitSpace() <-chan struct{} {
+	if q.space == nil {
+		q.space = make(chan struct{})
+	}
+	return q.space
+}
+
+// queueMessage puts a frame in its priority lane. When the lane is full it
+// fails with ErrQueueFull, or if block is set waits for room until ctx is
+// done or the client's I/O stops. The control lane is never full.
+func (c *Client) queueMessage(ctx context.Context, typ uint8, frame *Stream, block bool) error {
+	priority := messagePriority(typ)
+	c.lock.Lock()
+	for priority != QUEUE_PRIORITY_CONTROL && len(c.outputQueue.lanes[priority]) >= c.queueConfig.Capacity {
+		if !block {
+			c.lock.Unlock()
+			return ErrQueueFull
+		}
+		space, done := c.outputQueue.waitSpace(), c.done
+		c.lock.Unlock()
+		select {
+		case <-space:
+		case <-done:
+			return ErrNotConnected
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		c.lock.Lock()
+	}
+	c.outputQueue.lanes[priority] = append(c.outputQueue.lanes[priority], frame)
+	c.lock.Unlock()
+	Debug(PROTOCOL, "Putting %d bytes message on the output queue.", frame.Len())
+	c.notifyOutput()
+	return nil
+}
@@ -0,0 +1,134 @@
+package go_i2cp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/wkoomson/go-i2cp/message"
+)
+
+// sendCounter is a Transport that keeps every buffer passed to Send.
+type sendCounter struct {
+	Transport
+	sends [][]byte
+}
+
+func (s *sendCounter) Send(buf *Stream) (int, error) {
+	s.sends = append(s.sends, append([]byte(nil), buf.Bytes()...))
+	return buf.Len(), nil
+}
+
+func TestClient_QueuePriorities(t *testing.T) {
+	client := NewClient(nil)
+	transport := &sendCounter{}
+	client.SetTransport(transport)
+	client.SetQueueConfig(&QueueConfig{Capacity: 8, CoalesceSize: 64})
+	frames := []struct {
+		typ  uint8
+		size int
+	}{
+		{I2CP_MSG_SEND_MESSAGE, 20},
+		{I2CP_MSG_SEND_MESSAGE, 20},
+		{I2CP_MSG_HOST_LOOKUP, 10},
+		{I2CP_MSG_SEND_MESSAGE, 100},
+		{I2CP_MSG_DESTROY_SESSION, 2},
+	}
+	for _, f := range frames {
+		if err := client.sendMessage(rawMessage{typ: f.typ, body: make([]byte, f.size)}, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := client.flushOutputQueue(); err != nil {
+		t.Fatal(err)
+	}
+	// control and normal lane first, small frames coalesced up to 64 bytes
+	expected := [][]uint8{
+		{I2CP_MSG_DESTROY_SESSION, I2CP_MSG_HOST_LOOKUP, I2CP_MSG_SEND_MESSAGE},
+		{I2CP_MSG_SEND_MESSAGE},
+		{I2CP_MSG_SEND_MESSAGE},
+	}
+	if len(transport.sends) != len(expected) {
+		t.Fatalf("Expected %d sends, got %d", len(expected), len(transport.sends))
+	}
+	for i, send := range transport.sends {
+		var types []uint8
+		for len(send) > 0 {
+			typ, body, err := message.ReadFrame(bytes.NewReader(send), I2CP_MESSAGE_SIZE)
+			if err != nil {
+				t.Fatalf("Send %d is not a sequence of frames: %s", i, err.Error())
+			}
+			types = append(types, typ)
+			send = send[message.HeaderSize+len(body):]
+		}
+		if len(types) != len(expected[i]) {
+			t.Fatalf("Send %d: expected message types %v, got %v", i, expected[i], types)
+		}
+		for j := range types {
+			if types[j] != expected[i][j] {
+				t.Fatalf("Send %d: expected message types %v, got %v", i, expected[i], types)
+			}
+		}
+	}
+}
+
+func TestClient_QueueFull(t *testing.T) {
+	client := NewClient(nil)
+	client.SetQueueConfig(&QueueConfig{Capacity: 1, CoalesceSize: 1024})
+	session, err := NewSession(client, SessionCallbacks{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// there is no router, pretend it created the session
+	session.state = SESSION_STATE_CREATED
+	send := func(ctx context.Context) error {
+		return session.SendMessageContext(ctx, session.Destination(), PROTOCOL_DATAGRAM, 0, 0, NewStream([]byte("payload")), 0)
+	}
+	if err = session.SendMessage(session.Destination(), PROTOCOL_DATAGRAM, 0, 0, NewStream([]byte("payload")), 0); err != nil {
+		t.Fatalf("Could not queue message: %s", err.Error())
+	}
+	if err = session.SendMessage(session.Destination(), PROTOCOL_DATAGRAM, 0, 0, NewStream([]byte("payload")), 0); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("Expected ErrQueueFull, got %v", err)
+	}
+	// control messages have their own lane
+	if err = client.msgDestroySession(session, true); err != nil {
+		t.Fatalf("Could not queue DestroySession: %s", err.Error())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err = send(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Expected the deadline to expire, got %v", err)
+	}
+
+	result := make(chan error, 1)
+	go func() { result <- send(context.Background()) }()
+	select {
+	case err = <-result:
+		t.Fatalf("SendMessageContext returned %v with a full queue", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	client.SetTransport(&sendCounter{})
+	if err = client.flushOutputQueue(); err != nil {
+		t.Fatal(err)
+	}
+	if err = <-result; err != nil {
+		t.Fatalf("Could not queue message once there was room: %s", err.Error())
+	}
+}
+
+func TestClient_SetQueueConfigDefaults(t *testing.T) {
+	client := NewClient(nil)
+	client.SetQueueConfig(&QueueConfig{Capacity: 0, CoalesceSize: -1})
+	if client.queueConfig != *DefaultQueueConfig() {
+		t.Fatalf("Expected the default queue config, got %+v", client.queueConfig)
+	}
+	client.SetQueueConfig(&QueueConfig{Capacity: 1})
+	// the control lane takes more than Capacity messages
+	for i := 0; i < 3; i++ {
+		if err := client.queueMessage(context.Background(), I2CP_MSG_CREATE_LEASE_SET, NewStream([]byte{0}), false); err != nil {
+			t.Fatalf("Could not queue control message %d: %s", i, err.Error())
+		}
+	}
+}
@@ -0,0 +1,306 @@
+package go_i2cp
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+type ReconnectEventType int
+
+const (
+	RECONNECT_STARTED ReconnectEventType = iota
+	RECONNECT_ATTEMPT_FAILED
+	RECONNECT_SUCCEEDED
+	RECONNECT_GAVE_UP
+)
+
+// ReconnectEvent is passed to ClientCallBacks.OnReconnect while the client
+// tries to re-establish a lost router connection.
+type ReconnectEvent struct {
+	Type    ReconnectEventType
+	Attempt int
+	// Delay is the time waited before the next attempt, only set for
+	// RECONNECT_ATTEMPT_FAILED.
+	Delay  time.Duration
+	Reason string
+	Err    error
+}
+
+// ReconnectPolicy configures the exponential backoff used to reconnect to the
+// router. Reconnecting is disabled unless a policy is set with
+// Client.SetReconnectPolicy.
+type ReconnectPolicy struct {
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+	Multiplier   float64
+	// Jitter randomizes each delay by up to the given fraction, 0.2 turns a
+	// 10s delay into something between 8s and 12s.
+	Jitter float64
+	// MaxAttempts is the number of attempts before giving up, 0 retries forever.
+	MaxAttempts int
+	// AttemptTimeout bounds connecting, the handshake and restoring the
+	// sessions of one attempt, DialTimeout if 0.
+	AttemptTimeout time.Duration
+}
+
+func DefaultReconnectPolicy() *ReconnectPolicy {
+	return &ReconnectPolicy{
+		InitialDelay: 500 * time.Millisecond,
+		MaxDelay:     time.Minute,
+		Multiplier:   2,
+		Jitter:       0.2,
+	}
+}
+
+// delay returns the backoff before the given attempt, attempts start at 1.
+func (p *ReconnectPolicy) delay(attempt int) time.Duration {
+	delay := float64(p.InitialDelay)
+	for i := 1; i < attempt; i++ {
+		delay *= p.Multiplier
+		if p.MaxDelay > 0 && delay > float64(p.MaxDelay) {
+			break
+		}
+	}
+	if p.MaxDelay > 0 && delay > float64(p.MaxDelay) {
+		delay = float64(p.MaxDelay)
+	}
+	if p.Jitter > 0 {
+		delay += delay * p.Jitter * (2*rand.Float64() - 1)
+	}
+	if delay < 0 {
+		delay = 0
+	}
+	return time.Duration(delay)
+}
+
+// SetReconnectPolicy enables reconnecting with the given policy, nil disables it.
+func (c *Client) SetReconnectPolicy(policy *ReconnectPolicy) {
+	c.lock.Lock()
+	c.reconnectPolicy = policy
+	c.lock.Unlock()
+}
+
+// handleDisconnect reports a lost connection and reconnects when a policy is
+// set. It returns true if the connection was re-established.
+func (c *Client) handleDisconnect(reason string) bool {
+	c.lock.Lock()
+	reconnecting, closed, policy := c.reconnecting, c.closed, c.reconnectPolicy
+	c.lock.Unlock()
+	if reconnecting || closed {
+		return false
+	}
+	Info(TAG, "Lost connection to router: %s", reason)
+	if c.callbacks != nil && c.callbacks.onDisconnect != nil {
+		c.callbacks.onDisconnect(c, reason, nil)
+	}
+	if policy == nil {
+		return false
+	}
+	return c.reconnect(reason, policy)
+}
+
+func (c *Client) reconnect(reason string, policy *ReconnectPolicy) bool {
+	c.setReconnecting(true)
+	defer func() {
+		c.setReconnecting(false)
+		// messages queued meanwhile can go out on the new connection
+		c.notifyOutput()
+	}()
+	c.transport.Close()
+	c.dropPending()
+	// Disconnect and Close stop the I/O, that ends the backoff and the
+	// attempt in progress
+	c.lock.Lock()
+	done := c.done
+	c.lock.Unlock()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	c.emitReconnect(ReconnectEvent{Type: RECONNECT_STARTED, Reason: reason})
+	delay := policy.delay(1)
+	for attempt := 1; policy.MaxAttempts == 0 || attempt <= policy.MaxAttempts; attempt++ {
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return false
+		}
+		if c.isClosed() {
+			return false
+		}
+		Debug(TAG, "Reconnect attempt %d", attempt)
+		err := c.reconnectAttempt(ctx, policy)
+		if err == nil {
+			Info(TAG, "Reconnected to router after %d attempts", attempt)
+			c.emitReconnect(ReconnectEvent{Type: RECONNECT_SUCCEEDED, Attempt: attempt, Reason: reason})
+			return true
+		}
+		c.transport.Close()
+		delay = policy.delay(attempt + 1)
+		Warning(TAG, "Reconnect attempt %d failed: %s", attempt, err.Error())
+		c.emitReconnect(ReconnectEvent{Type: RECONNECT_ATTEMPT_FAILED, Attempt: attempt, Delay: delay, Reason: reason, Err: err})
+	}
+	Error(TAG, "Giving up reconnecting after %d attempts", policy.MaxAttempts)
+	c.emitReconnect(ReconnectEvent{Type: RECONNECT_GAVE_UP, Attempt: policy.MaxAttempts, Reason: reason})
+	return false
+}
+
+// reconnectAttempt connects, performs the handshake and restores the
+// sessions within the policy's AttemptTimeout.
+func (c *Client) reconnectAttempt(ctx context.Context, policy *ReconnectPolicy) (err error) {
+	timeout := policy.AttemptTimeout
+	if timeout <= 0 {
+		timeout = DialTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	c.sendLock.Lock()
+	err = connectTransport(ctx, c.transport)
+	c.sendLock.Unlock()
+	if err != nil {
+		return
+	}
+	// closing the transport unblocks a router that doesn't answer
+	stop := context.AfterFunc(ctx, func() { c.transport.Close() })
+	err = c.handshake(ctx)
+	if err == nil {
+		err = c.restoreSessions()
+	}
+	if !stop() {
+		return ctx.Err()
+	}
+	return
+}
+
+func (c *Client) setReconnecting(reconnecting bool) {
+	c.lock.Lock()
+	c.reconnecting = reconnecting
+	c.lock.Unlock()
+}
+
+// dropPending forgets everything that was bound to the lost connection,
+// queued messages still carry the old session ids.
+func (c *Client) dropPending() {
+	c.lock.Lock()
+	c.outputQueue.reset()
+	// a session being created went away with the old connection, the
+	// waiting CreateSession fails
+	if c.currentSession != nil {
+		c.currentSession.setStateLocked(SESSION_STATE_INVALID)
+		c.currentSession = nil
+	}
+	if c.sessionWaiter != nil {
+		close(c.sessionWaiter)
+		c.sessionWaiter = nil
+	}
+	c.pingTime = time.Time{}
+	c.missedPings = 0
+	// sessions being destroyed went away with the old connection, don't
+	// restore them
+	for id, waiter := range c.destroyWaiters {
+		if sess := c.sessions[id]; sess != nil {
+			sess.setStateLocked(SESSION_STATE_DESTROYED)
+		}
+		delete(c.sessions, id)
+		delete(c.destroyWaiters, id)
+		close(waiter)
+	}
+	// the sessions can't send until restoreSessions re-created them, their
+	// messages would carry the old session ids
+	for _, sess := range c.sessions {
+		sess.setStateLocked(SESSION_STATE_PENDING)
+	}
+	// the request went away with the old connection
+	for _, waiter := range c.bandwidthWaiters {
+		close(waiter)
+	}
+	c.bandwidthWaiters = nil
+	// the router won't answer the pending ReconfigureSession, the new
+	// config is sent when the session is restored
+	for _, waiter := range c.reconfigureWaiters {
+		close(waiter)
+	}
+	c.reconfigureWaiters = make(map[uint16]chan SessionStatus)
+	// the router won't answer the pending lookups anymore, waiting
+	// LookupContext calls fail and the callbacks get no destination
+	var failed map[uint32]LookupEntry
+	for requestId, lup := range c.lookupReq {
+		if lup.waiter != nil {
+			close(lup.waiter)
+			continue
+		}
+		if failed == nil {
+			failed = make(map[uint32]LookupEntry)
+		}
+		failed[requestId] = lup
+	}
+	c.lookup = make(map[string]uint32, 1000)
+	c.lookupReq = make(map[uint32]LookupEntry, 1000)
+	c.lock.Unlock()
+	for requestId, lup := range failed {
+		lup.dispatch(requestId, lup.address, nil)
+	}
+}
+
+// restoreSessions re-creates every known session on the new connection with
+// its existing destination and config, the router assigns new session ids.
+func (c *Client) restoreSessions() error {
+	c.lock.Lock()
+	ids := make([]int, 0, len(c.sessions))
+	for id := range c.sessions {
+		ids = append(ids, int(id))
+	}
+	sort.Ints(ids)
+	old := c.sessions
+	c.sessions = make(map[uint16]*Session)
+	c.lock.Unlock()
+	for _, id := range ids {
+		sess := old[uint16(id)]
+		c.lock.Lock()
+		sess.setStateLocked(SESSION_STATE_PENDING)
+		c.currentSession = sess
+		c.lock.Unlock()
+		err := c.msgCreateSession(sess.config, false)
+		for err == nil && c.pendingSession() != nil {
+			err = c.recvMessage(I2CP_MSG_ANY, true)
+		}
+		if err != nil {
+			// the sessions are keyed by pointer on the next attempt
+			c.lock.Lock()
+			c.currentSession = nil
+			c.sessions = old
+			c.lock.Unlock()
+			return err
+		}
+		c.lock.Lock()
+		restored := c.sessions[sess.id] == sess
+		c.lock.Unlock()
+		if !restored {
+			Warning(TAG, "Router refused to restore session %d", id)
+			continue
+		}
+		Debug(TAG, "Session %d restored as session %d", id, c.sessionId(sess))
+	}
+	return nil
+}
+
+func (c *Client) pendingSession() *Session {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.currentSession
+}
+
+func (c *Client) emitReconnect(event ReconnectEvent) {
+	if c.callbacks != nil && c.callbacks.OnReconnect != nil {
+		c.callbacks.OnReconnect(c, event)
+	}
+}
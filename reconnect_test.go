@@ -0,0 +1,22 @@
+package go_i2cp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReconnectPolicy_Delay(t *testing.T) {
+	policy := ReconnectPolicy{InitialDelay: time.Second, MaxDelay: 10 * time.Second, Multiplier: 2}
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second}
+	for i, delay := range expected {
+		if d := policy.delay(i + 1); d != delay {
+			t.Fatalf("Attempt %d: expected delay %s, got %s", i+1, delay, d)
+		}
+	}
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if d := policy.delay(1); d < 500*time.Millisecond || d > 1500*time.Millisecond {
+			t.Fatalf("Jittered delay %s out of bounds", d)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package go_i2cp
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/wkoomson/go-i2cp/capture"
+	"github.com/wkoomson/go-i2cp/message"
+)
+
+// Recorder is a Transport that writes every frame passing through the
+// wrapped transport to a capture. The protocol byte sent when connecting is
+// not recorded.
+type Recorder struct {
+	Transport
+	w    *capture.Writer
+	lock sync.Mutex
+	out  frameSplitter
+	in   frameSplitter
+}
+
+// NewRecorder records the frames sent and received over t to w.
+func NewRecorder(t Transport, w *capture.Writer) *Recorder {
+	return &Recorder{Transport: t, w: w}
+}
+
+// SetCapture records every frame exchanged with the router to w. It wraps
+// the current transport, so call it after SetTransport and before Connect.
+func (c *Client) SetCapture(w *capture.Writer) {
+	c.transport = NewRecorder(c.transport, w)
+}
+
+func (r *Recorder) Connect() error {
+	return r.ConnectContext(context.Background())
+}
+
+// ConnectContext connects the wrapped transport and starts a new recording
+// of the connection.
+func (r *Recorder) ConnectContext(ctx context.Context) error {
+	if err := connectTransport(ctx, r.Transport); err != nil {
+		return err
+	}
+	r.lock.Lock()
+	r.out = frameSplitter{skip: 1}
+	r.in = frameSplitter{}
+	r.lock.Unlock()
+	return nil
+}
+
+// Endpoint returns the endpoint of the wrapped transport, if it has one.
+func (r *Recorder) Endpoint() string {
+	if t, ok := r.Transport.(interface{ Endpoint() string }); ok {
+		return t.Endpoint()
+	}
+	return ""
+}
+
+func (r *Recorder) Send(buf *Stream) (n int, err error) {
+	n, err = r.Transport.Send(buf)
+	if n > 0 {
+		r.record(capture.ClientToRouter, &r.out, buf.Bytes()[:n])
+	}
+	return
+}
+
+func (r *Recorder) Receive(buf *Stream) (n int, err error) {
+	n, err = r.Transport.Receive(buf)
+	if n > 0 {
+		r.record(capture.RouterToClient, &r.in, buf.Bytes()[:n])
+	}
+	return
+}
+
+func (r *Recorder) record(direction capture.Direction, s *frameSplitter, b []byte) {
+	r.lock.Lock()
+	frames := s.write(b)
+	r.lock.Unlock()
+	now := time.Now()
+	for _, frame := range frames {
+		rec := capture.Record{Time: now, Direction: direction, Type: frame[4], Body: frame[message.HeaderSize:]}
+		if direction == capture.ClientToRouter {
+			rec.Body = message.Redact(rec.Type, rec.Body)
+		}
+		if err := r.w.Write(rec); err != nil {
+			Warning(TAG, "Could not write capture record: %s", err.Error())
+		}
+	}
+}
+
+// frameSplitter collects the bytes of one direction and cuts them into
+// frames.
+type frameSplitter struct {
+	// skip is the number of bytes to drop before the first frame
+	skip int
+	buf  []byte
+}
+
+func (s *frameSplitter) write(b []byte) (frames [][]byte) {
+	if s.skip > 0 {
+		n := s.skip
+		if n > len(b) {
+			n = len(b)
+		}
+		s.skip -= n
+		b = b[n:]
+	}
+	s.buf = append(s.buf, b...)
+	for len(s.buf) >= message.HeaderSize {
+		length := int(binary.BigEndian.Uint32(s.buf))
+		if len(s.buf) < message.HeaderSize+length {
+			break
+		}
+		frame := make([]byte, message.HeaderSize+length)
+		copy(frame, s.buf)
+		s.buf = s.buf[len(frame):]
+		frames = append(frames, frame)
+	}
+	return
+}
+
+// ReplayMismatchError is returned by Replayer when the client sends another
+// message than the capture has at that point.
+type ReplayMismatchError struct {
+	// Index is the index of the record in the capture.
+	Index    int
+	Expected uint8
+	Got      uint8
+}
+
+func (e *ReplayMismatchError) Error() string {
+	return fmt.Sprintf("i2cp: replay record %d expected message type %d from the client, got %d", e.Index, e.Expected, e.Got)
+}
+
+// Replayer plays the router side of a capture. The router's frames are sent
+// as recorded, each one once the client sent the frames recorded before it.
+// Frames from the client are only compared by message type.
+type Replayer struct {
+	records []capture.Record
+	played  chan struct{}
+	done    chan struct{}
+	err     error
+}
+
+// NewReplayer returns a Replayer for records.
+func NewReplayer(records []capture.Record) *Replayer {
+	return &Replayer{records: records, played: make(chan struct{}), done: make(chan struct{})}
+}
+
+// Transport returns a Transport for Client.SetTransport whose router end is
+// served by the Replayer.
+func (r *Replayer) Transport() Transport {
+	pipe, router := NewPipe()
+	go r.Serve(router)
+	return pipe
+}
+
+// Serve plays the capture on conn, the router end of a client connection,
+// and waits for the client to close the connection afterwards. It can be
+// used once.
+func (r *Replayer) Serve(conn net.Conn) (err error) {
+	defer func() {
+		conn.Close()
+		r.err = err
+		close(r.done)
+	}()
+	protocol := make([]byte, 1)
+	if _, err = io.ReadFull(conn, protocol); err != nil {
+		return
+	}
+	if protocol[0] != I2CP_PROTOCOL_INIT {
+		return fmt.Errorf("i2cp: replay expected protocol byte %#x, got %#x", I2CP_PROTOCOL_INIT, protocol[0])
+	}
+	for i, rec := range r.records {
+		switch rec.Direction {
+		case capture.RouterToClient:
+			if err = message.Write(conn, rawMessage{typ: rec.Type, body: rec.Body}); err != nil {
+				return
+			}
+		case capture.ClientToRouter:
+			var typ uint8
+			if typ, _, err = message.ReadFrame(conn, I2CP_MESSAGE_SIZE); err != nil {
+				return
+			}
+			if typ != rec.Type {
+				return &ReplayMismatchError{Index: i, Expected: rec.Type, Got: typ}
+			}
+		}
+	}
+	close(r.played)
+	for {
+		if _, _, err = message.ReadFrame(conn, I2CP_MESSAGE_SIZE); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrClosedPipe) {
+				err = nil
+			}
+			return
+		}
+	}
+}
+
+// Played returns a channel that is closed once every record was replayed.
+func (r *Replayer) Played() <-chan struct{} {
+	return r.played
+}
+
+// Wait blocks until Serve returned and returns its error.
+func (r *Replayer) Wait() error {
+	<-r.done
+	return r.err
+}
+
+// rawMessage is a recorded message, it is replayed without decoding.
+type rawMessage struct {
+	typ  uint8
+	body []byte
+}
+
+func (m rawMessage) Type() uint8 { return m.typ }
+
+func (m rawMessage) Marshal() ([]byte, error) { return m.body, nil }
+
+func (m rawMessage) Unmarshal(body []byte) error {
+	return errors.New("i2cp: raw messages can't be decoded")
+}
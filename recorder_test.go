@@ -0,0 +1,118 @@
+package go_i2cp
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/wkoomson/go-i2cp/capture"
+)
+
+// recordSession creates and destroys a session against the fake router and
+// returns the capture of it.
+func recordSession(t *testing.T) []capture.Record {
+	router, client := startRouter(t)
+	leaseSet := make(chan struct{})
+	router.OnReceive = func(typ uint8, body []byte) {
+		if typ == I2CP_MSG_CREATE_LEASE_SET {
+			close(leaseSet)
+		}
+	}
+	var buf bytes.Buffer
+	w, err := capture.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetCapture(w)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err = client.ConnectContext(ctx); err != nil {
+		t.Fatalf("Could not connect: %s", err.Error())
+	}
+	session, _ := NewSession(client, SessionCallbacks{})
+	if err = client.CreateSessionContext(ctx, session); err != nil {
+		t.Fatalf("Could not create session: %s", err.Error())
+	}
+	// the lease set is sent after CreateSession returned
+	select {
+	case <-leaseSet:
+	case <-ctx.Done():
+		t.Fatal("Router did not receive the lease set")
+	}
+	if err = client.Close(ctx); err != nil {
+		t.Fatalf("Could not close client: %s", err.Error())
+	}
+	records, err := capture.ReadAll(&buf)
+	if err != nil {
+		t.Fatalf("Could not read capture: %s", err.Error())
+	}
+	return records
+}
+
+func TestRecorder(t *testing.T) {
+	records := recordSession(t)
+	expected := []struct {
+		direction capture.Direction
+		typ       uint8
+	}{
+		{capture.ClientToRouter, I2CP_MSG_GET_DATE},
+		{capture.RouterToClient, I2CP_MSG_SET_DATE},
+		{capture.ClientToRouter, I2CP_MSG_CREATE_SESSION},
+		{capture.RouterToClient, I2CP_MSG_SESSION_STATUS},
+		{capture.RouterToClient, I2CP_MSG_REQUEST_VARIABLE_LEASESET},
+		{capture.ClientToRouter, I2CP_MSG_CREATE_LEASE_SET},
+		{capture.ClientToRouter, I2CP_MSG_DESTROY_SESSION},
+		{capture.RouterToClient, I2CP_MSG_SESSION_STATUS},
+		{capture.ClientToRouter, I2CP_MSG_DISCONNECT},
+	}
+	if len(records) != len(expected) {
+		t.Fatalf("Expected %d records, got %d", len(expected), len(records))
+	}
+	for i, rec := range records {
+		if rec.Direction != expected[i].direction || rec.Type != expected[i].typ {
+			t.Fatalf("Record %d: expected %s type %d, got %s type %d", i, expected[i].direction, expected[i].typ, rec.Direction, rec.Type)
+		}
+	}
+}
+
+func TestReplayer(t *testing.T) {
+	// replay up to the lease set, the client sends it on its own
+	records := recordSession(t)[:6]
+	replayer := NewReplayer(records)
+	client := NewClient(nil)
+	client.SetTransport(replayer.Transport())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.ConnectContext(ctx); err != nil {
+		t.Fatalf("Could not connect to replayed router: %s", err.Error())
+	}
+	session, _ := NewSession(client, SessionCallbacks{})
+	if err := client.CreateSessionContext(ctx, session); err != nil {
+		t.Fatalf("Could not create session on replayed router: %s", err.Error())
+	}
+	select {
+	case <-replayer.Played():
+	case <-ctx.Done():
+		t.Fatal("Capture was not replayed")
+	}
+	client.Disconnect()
+	if err := replayer.Wait(); err != nil {
+		t.Fatalf("Replay failed: %s", err.Error())
+	}
+}
+
+func TestReplayer_Mismatch(t *testing.T) {
+	replayer := NewReplayer([]capture.Record{
+		{Direction: capture.ClientToRouter, Type: I2CP_MSG_GET_BANDWIDTH_LIMITS},
+	})
+	client := NewClient(nil)
+	client.SetTransport(replayer.Transport())
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	client.ConnectContext(ctx)
+	mismatch, ok := replayer.Wait().(*ReplayMismatchError)
+	if !ok || mismatch.Got != I2CP_MSG_GET_DATE {
+		t.Fatalf("Expected a mismatch on GetDate, got %v", replayer.Wait())
+	}
+}
@@ -0,0 +1,78 @@
+package go_i2cp
+
+import "time"
+
+/* Router capabilities */
+const (
+	// ROUTER_CAN_HOST_LOOKUP is set for routers that answer HostLookup,
+	// older ones only resolve hashes with DestLookup.
+	ROUTER_CAN_HOST_LOOKUP uint32 = 1 << iota
+	// ROUTER_CAN_VARIABLE_LEASESET is set for routers that request lease
+	// sets with RequestVariableLeaseSet.
+	ROUTER_CAN_VARIABLE_LEASESET
+	// ROUTER_CAN_LEASESET2 is set for routers that accept CreateLeaseSet2.
+	ROUTER_CAN_LEASESET2
+	// ROUTER_CAN_SEND_MESSAGE_EXPIRES is set for routers that accept
+	// SendMessageExpires.
+	ROUTER_CAN_SEND_MESSAGE_EXPIRES
+	// ROUTER_CAN_BLINDING_INFO is set for routers that accept BlindingInfo
+	// for encrypted lease sets.
+	ROUTER_CAN_BLINDING_INFO
+	// ROUTER_CAN_SUBSESSIONS is set for routers that support more than one
+	// session sharing the tunnels of a primary session.
+	ROUTER_CAN_SUBSESSIONS
+	// ROUTER_CAN_GETDATE_AUTH is set for routers that authenticate the
+	// client with the credentials in GetDate, older ones read them from
+	// CreateSession.
+	ROUTER_CAN_GETDATE_AUTH
+)
+
+// routerFeatures is the router version each capability appeared in.
+var routerFeatures = []struct {
+	capability uint32
+	since      Version
+}{
+	{ROUTER_CAN_SEND_MESSAGE_EXPIRES, Version{Major: 0, Minor: 7, Micro: 1}},
+	{ROUTER_CAN_VARIABLE_LEASESET, Version{Major: 0, Minor: 9, Micro: 7}},
+	{ROUTER_CAN_HOST_LOOKUP, Version{Major: 0, Minor: 9, Micro: 11}},
+	{ROUTER_CAN_GETDATE_AUTH, Version{Major: 0, Minor: 9, Micro: 11}},
+	{ROUTER_CAN_SUBSESSIONS, Version{Major: 0, Minor: 9, Micro: 21}},
+	{ROUTER_CAN_LEASESET2, Version{Major: 0, Minor: 9, Micro: 39}},
+	{ROUTER_CAN_BLINDING_INFO, Version{Major: 0, Minor: 9, Micro: 43}},
+}
+
+// RouterInfo is what the client learned about the router from its SetDate
+// answer.
+type RouterInfo struct {
+	// Date is the router's time when it sent SetDate.
+	Date    time.Time
+	Version Version
+	// Capabilities is a set of ROUTER_CAN_* flags derived from Version.
+	Capabilities uint32
+}
+
+func newRouterInfo(date uint64, version string) RouterInfo {
+	info := RouterInfo{
+		Date:    time.Unix(0, int64(date)*int64(time.Millisecond)),
+		Version: ParseVersion(version),
+	}
+	for _, feature := range routerFeatures {
+		if info.Version.AtLeast(feature.since) {
+			info.Capabilities |= feature.capability
+		}
+	}
+	return info
+}
+
+// Supports reports whether the router has every capability in capabilities.
+func (r RouterInfo) Supports(capabilities uint32) bool {
+	return r.Capabilities&capabilities == capabilities
+}
+
+// RouterInfo returns what the client knows about the router it is connected
+// to, it is the zero value before the first handshake.
+func (c *Client) RouterInfo() RouterInfo {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.router
+}
@@ -1,5 +1,11 @@
 package go_i2cp
 
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
 type SessionMessageStatus int
 
 const (
@@ -26,6 +32,24 @@ const (
 	I2CP_MSG_STATUS_MESSAGE_NO_LEASESET
 )
 
+var messageStatusNames = [...]string{
+	"AVAILABLE", "ACCEPTED", "BEST_EFFORT_SUCCESS", "BEST_EFFORT_FAILURE",
+	"GUARANTEED_SUCCESS", "GUARANTEED_FAILURE", "LOCAL_SUCCESS", "LOCAL_FAILURE",
+	"ROUTER_FAILURE", "NETWORK_FAILURE", "BAD_SESSION", "BAD_MESSAGE",
+	"OVERFLOW_FAILURE", "MESSAGE_EXPIRED", "MESSAGE_BAD_LOCAL_LEASESET",
+	"MESSAGE_NO_LOCAL_TUNNELS", "MESSAGE_UNSUPPORTED_ENCRYPTION",
+	"MESSAGE_BAD_DESTINATION", "MESSAGE_BAD_LEASESET", "MESSAGE_EXPIRED_LEASESET",
+	"MESSAGE_NO_LEASESET",
+}
+
+// String returns the constant name without the I2CP_MSG_STATUS_ prefix.
+func (status SessionMessageStatus) String() string {
+	if status >= 0 && int(status) < len(messageStatusNames) {
+		return messageStatusNames[status]
+	}
+	return fmt.Sprintf("STATUS(%d)", int(status))
+}
+
 type SessionStatus int
 
 const (
@@ -35,6 +59,21 @@ const (
 	I2CP_SESSION_STATUS_INVALID
 )
 
+// String returns the constant name without the I2CP_SESSION_STATUS_ prefix.
+func (status SessionStatus) String() string {
+	switch status {
+	case I2CP_SESSION_STATUS_DESTROYED:
+		return "DESTROYED"
+	case I2CP_SESSION_STATUS_CREATED:
+		return "CREATED"
+	case I2CP_SESSION_STATUS_UPDATED:
+		return "UPDATED"
+	case I2CP_SESSION_STATUS_INVALID:
+		return "INVALID"
+	}
+	return fmt.Sprintf("STATUS(%d)", int(status))
+}
+
 type SessionCallbacks struct {
 	onMessage     func(session *Session, protocol uint8, srcPort, destPort uint16, payload *Stream)
 	onStatus      func(session *Session, status SessionStatus)
@@ -42,22 +81,67 @@ type SessionCallbacks struct {
 }
 
 type Session struct {
-	id        uint16
-	config    *SessionConfig
-	client    *Client
-	callbacks *SessionCallbacks
+	id          uint16
+	config      *SessionConfig
+	client      *Client
+	callbacks   *SessionCallbacks
+	rateLimiter *rateLimiter
+	// reconfigureLock allows one Reconfigure at a time
+	reconfigureLock sync.Mutex
+	// primary and subsessions link subsessions to their primary session,
+	// guarded by the client's lock
+	primary     *Session
+	subsessions []*Session
+	// state, published and stateChanged are guarded by the client's lock,
+	// published is set once a lease set was sent and stateChanged is closed
+	// on every transition
+	state        SessionState
+	published    bool
+	stateChanged chan struct{}
 }
 
-func NewSession(client *Client, callbacks SessionCallbacks) (sess *Session) {
+func NewSession(client *Client, callbacks SessionCallbacks) (sess *Session, err error) {
+	var dest *Destination
+	if dest, err = NewDestination(); err != nil {
+		return
+	}
 	sess = &Session{}
 	sess.client = client
-	dest, _ := NewDestination()
 	sess.config = &SessionConfig{destination: dest}
 	sess.callbacks = &callbacks
 	return
 }
-func (session *Session) SendMessage(destination *Destination, protocol uint8, srcPort, destPort uint16, payload *Stream, nonce uint32) {
-	session.client.msgSendMessage(session, destination, protocol, srcPort, destPort, payload, nonce, true)
+
+// SendMessage queues a message to destination, it fails with a
+// *SessionStateError when the session isn't created or was destroyed, with
+// ErrRateLimited when the session's or the client's rate limit has no room
+// for it and with ErrQueueFull when the client's output queue is full.
+func (session *Session) SendMessage(destination *Destination, protocol uint8, srcPort, destPort uint16, payload *Stream, nonce uint32) error {
+	return session.client.msgSendMessage(context.Background(), session, destination, protocol, srcPort, destPort, payload, nonce, false)
+}
+
+// SendMessageContext queues a message to destination like SendMessage, but
+// waits for the rate limits and for room in a full output queue until ctx is
+// done.
+func (session *Session) SendMessageContext(ctx context.Context, destination *Destination, protocol uint8, srcPort, destPort uint16, payload *Stream, nonce uint32) error {
+	return session.client.msgSendMessage(ctx, session, destination, protocol, srcPort, destPort, payload, nonce, true)
+}
+
+// Reconfigure changes the session's options on the router without losing its
+// tunnels, e.g. SESSION_CONFIG_PROP_INBOUND_QUANTITY or the nicknames. An
+// empty value removes the option. It waits until the router confirmed the
+// new config, if the router refuses it, ctx is done or the client
+// disconnects the previous config is restored locally. When the connection
+// is lost and the client reconnects, ErrRouterDisconnected is returned and
+// the session is re-created with the new config.
+func (session *Session) Reconfigure(ctx context.Context, changes map[SessionConfigProperty]string) error {
+	return session.client.ReconfigureSessionContext(ctx, session, changes)
+}
+
+// Close destroys the session on the router, the subsessions of a primary
+// session are destroyed with it.
+func (session *Session) Close() error {
+	return session.client.DestroySession(session)
 }
 func (session *Session) Destination() *Destination {
 	return session.config.destination
@@ -2,9 +2,12 @@ package go_i2cp
 
 import (
 	"bufio"
+	"fmt"
 	"os"
 	"regexp"
 	"time"
+
+	"github.com/wkoomson/go-i2cp/message"
 )
 
 type SessionConfigProperty int
@@ -17,7 +20,11 @@ const (
 	SESSION_CONFIG_PROP_I2CP_FAST_RECEIVE
 	SESSION_CONFIG_PROP_I2CP_GZIP
 	SESSION_CONFIG_PROP_I2CP_MESSAGE_RELIABILITY
+	// Deprecated: use Client.SetCredentials. They become the client's
+	// credentials if it has none.
 	SESSION_CONFIG_PROP_I2CP_PASSWORD
+	// Deprecated: use Client.SetCredentials. They become the client's
+	// credentials if it has none.
 	SESSION_CONFIG_PROP_I2CP_USERNAME
 
 	SESSION_CONFIG_PROP_INBOUND_ALLOW_ZERO_HOP
@@ -75,52 +82,89 @@ type SessionConfig struct {
 	destination *Destination
 }
 
-func NewSessionConfigFromDestinationFile(filename string) (config SessionConfig) {
+func NewSessionConfigFromDestinationFile(filename string) (config SessionConfig, err error) {
 	var home string
-	if file, err := os.Open(filename); err == nil {
-		config.destination, err = NewDestinationFromFile(file)
-		if err != nil {
+	if file, ferr := os.Open(filename); ferr == nil {
+		config.destination, ferr = NewDestinationFromFile(file)
+		file.Close()
+		if ferr != nil {
 			Warning(SESSION_CONFIG, "Failed to load destination from file '%s', a new destination will be generated.", filename)
 		}
 	}
 	if config.destination == nil {
-		config.destination, _ = NewDestination()
+		if config.destination, err = NewDestination(); err != nil {
+			return
+		}
 	}
 	if len(filename) > 0 {
-		config.destination.WriteToFile(filename)
+		if err = config.destination.WriteToFile(filename); err != nil {
+			return
+		}
 	}
 	home = os.Getenv("HOME")
 	if len(home) > 0 {
 		configFile := home + "/.i2cp.conf"
-		ParseConfig(configFile, func(name, value string) {
+		err = ParseConfig(configFile, func(name, value string) {
 			if prop := config.propFromString(name); prop >= 0 {
 				config.SetProperty(prop, value)
 			}
 		})
+		if os.IsNotExist(err) {
+			err = nil
+		}
 	}
-	return config
+	return
 }
-func (config *SessionConfig) writeToMessage(stream *Stream) {
-	config.destination.WriteToMessage(stream)
-	config.writeMappingToMessage(stream)
-	stream.WriteUint64(uint64(time.Now().Unix() * 1000))
-	GetCryptoInstance().WriteSignatureToStream(&config.destination.sgk, stream)
+
+// toMessage returns the config for CreateSession and ReconfigureSession
+// signed with the destination's key, date should be the router's time. auth
+// is added to the options for routers that authenticate on CreateSession.
+func (config *SessionConfig) toMessage(date time.Time, auth *Credentials) (msg message.SessionConfig, err error) {
+	dest := NewStream(make([]byte, 0, 512))
+	if err = config.destination.WriteToMessage(dest); err != nil {
+		return
+	}
+	msg = message.SessionConfig{
+		Destination: dest.Bytes(),
+		Options:     config.options(auth),
+		Date:        uint64(date.UnixNano() / int64(time.Millisecond)),
+	}
+	// the signature covers everything before it, which is a CreateSession
+	// without signature
+	var signed []byte
+	if signed, err = (&message.CreateSession{SessionConfig: msg}).Marshal(); err != nil {
+		return
+	}
+	stream := NewStream(signed)
+	if err = GetCryptoInstance().SignStream(&config.destination.sgk, stream); err != nil {
+		return
+	}
+	msg.Signature = stream.Bytes()[len(signed):]
+	return
 }
-func (config *SessionConfig) writeMappingToMessage(stream *Stream) (err error) {
+func (config *SessionConfig) options(auth *Credentials) map[string]string {
 	m := make(map[string]string)
 	for i := 0; i < int(NR_OF_SESSION_CONFIG_PROPERTIES); i++ {
 		var option string
 		if config.properties[i] == "" {
 			continue
 		}
+		switch SessionConfigProperty(i) {
+		case SESSION_CONFIG_PROP_I2CP_USERNAME, SESSION_CONFIG_PROP_I2CP_PASSWORD:
+			continue
+		}
 		option = config.configOptLookup(SessionConfigProperty(i))
 		if option == "" {
 			continue
 		}
 		m[option] = config.properties[i]
 	}
+	if auth != nil {
+		m["i2cp.username"] = auth.Username
+		m["i2cp.password"] = auth.Password
+	}
 	Debug(SESSION_CONFIG, "Writing %d options to mapping table", len(m))
-	return stream.WriteMapping(m)
+	return m
 }
 func (config *SessionConfig) configOptLookup(property SessionConfigProperty) string {
 	return sessionOptions[property]
@@ -136,12 +180,14 @@ func (config *SessionConfig) propFromString(name string) SessionConfigProperty {
 func (config *SessionConfig) SetProperty(prop SessionConfigProperty, value string) {
 	config.properties[prop] = value
 }
-func ParseConfig(s string, cb func(string, string)) {
+
+// ParseConfig calls cb for every name=value; pair in the config file s.
+func ParseConfig(s string, cb func(string, string)) error {
 	file, err := os.Open(s)
 	if err != nil {
-		Error(SESSION_CONFIG, err.Error())
-		return
+		return err
 	}
+	defer file.Close()
 	Debug(SESSION_CONFIG, "Parsing config file '%s'", s)
 	scan := bufio.NewScanner(file)
 	for scan.Scan() {
@@ -153,6 +199,7 @@ func ParseConfig(s string, cb func(string, string)) {
 		cb(groups[1], groups[2])
 	}
 	if err := scan.Err(); err != nil {
-		Error(SESSION_CONFIG, "reading input from %s config %s", s, err.Error())
+		return fmt.Errorf("i2cp: reading config %s: %w", s, err)
 	}
+	return nil
 }
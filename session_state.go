@@ -0,0 +1,131 @@
+package go_i2cp
+
+import (
+	"context"
+	"fmt"
+)
+
+// SessionState is the life cycle state of a Session on the client side.
+type SessionState int
+
+const (
+	// SESSION_STATE_PENDING is a session that isn't created on the router
+	// yet, or is being re-created after a reconnect.
+	SESSION_STATE_PENDING SessionState = iota
+	// SESSION_STATE_CREATED is a session the router created, it has no
+	// lease set yet so it can't receive messages.
+	SESSION_STATE_CREATED
+	// SESSION_STATE_LEASESET_PUBLISHED is a session whose signed lease set
+	// was sent to the router.
+	SESSION_STATE_LEASESET_PUBLISHED
+	// SESSION_STATE_UPDATED is a session the router reconfigured.
+	SESSION_STATE_UPDATED
+	// SESSION_STATE_DESTROYED is a session the router destroyed.
+	SESSION_STATE_DESTROYED
+	// SESSION_STATE_INVALID is a session the router refused or invalidated.
+	SESSION_STATE_INVALID
+)
+
+var sessionStateNames = [...]string{
+	"PENDING", "CREATED", "LEASESET_PUBLISHED", "UPDATED", "DESTROYED", "INVALID",
+}
+
+// String returns the constant name without the SESSION_STATE_ prefix.
+func (state SessionState) String() string {
+	if state >= 0 && int(state) < len(sessionStateNames) {
+		return sessionStateNames[state]
+	}
+	return fmt.Sprintf("STATE(%d)", int(state))
+}
+
+// usable reports whether messages can be sent from a session in state.
+func (state SessionState) usable() bool {
+	switch state {
+	case SESSION_STATE_CREATED, SESSION_STATE_LEASESET_PUBLISHED, SESSION_STATE_UPDATED:
+		return true
+	}
+	return false
+}
+
+// sessionTransitions lists the states each state can change to. Every
+// session can go back to pending, it is re-created after a reconnect or by
+// another CreateSession.
+var sessionTransitions = [...][]SessionState{
+	SESSION_STATE_PENDING:            {SESSION_STATE_PENDING, SESSION_STATE_CREATED, SESSION_STATE_DESTROYED, SESSION_STATE_INVALID},
+	SESSION_STATE_CREATED:            {SESSION_STATE_PENDING, SESSION_STATE_LEASESET_PUBLISHED, SESSION_STATE_UPDATED, SESSION_STATE_DESTROYED, SESSION_STATE_INVALID},
+	SESSION_STATE_LEASESET_PUBLISHED: {SESSION_STATE_PENDING, SESSION_STATE_LEASESET_PUBLISHED, SESSION_STATE_UPDATED, SESSION_STATE_DESTROYED, SESSION_STATE_INVALID},
+	SESSION_STATE_UPDATED:            {SESSION_STATE_PENDING, SESSION_STATE_LEASESET_PUBLISHED, SESSION_STATE_UPDATED, SESSION_STATE_DESTROYED, SESSION_STATE_INVALID},
+	SESSION_STATE_DESTROYED:          {SESSION_STATE_PENDING, SESSION_STATE_DESTROYED},
+	SESSION_STATE_INVALID:            {SESSION_STATE_PENDING, SESSION_STATE_DESTROYED, SESSION_STATE_INVALID},
+}
+
+// State returns the session's current state.
+func (session *Session) State() SessionState {
+	c := session.client
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return session.state
+}
+
+// WaitReady waits until the session is created and its signed lease set was
+// sent to the router, so other destinations can reach it. It fails with a
+// *SessionStateError once the session is destroyed or invalid.
+func (session *Session) WaitReady(ctx context.Context) error {
+	c := session.client
+	for {
+		c.lock.Lock()
+		state := session.state
+		ready := state == SESSION_STATE_LEASESET_PUBLISHED || (state == SESSION_STATE_UPDATED && session.published)
+		if session.stateChanged == nil {
+			session.stateChanged = make(chan struct{})
+		}
+		changed := session.stateChanged
+		id := session.id
+		c.lock.Unlock()
+		switch {
+		case ready:
+			return nil
+		case state == SESSION_STATE_DESTROYED || state == SESSION_STATE_INVALID:
+			return &SessionStateError{SessionId: id, State: state}
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// setStateLocked moves the session to next and wakes up WaitReady, c.lock
+// must be held. A transition the state machine doesn't allow is refused.
+func (session *Session) setStateLocked(next SessionState) error {
+	allowed := false
+	for _, state := range sessionTransitions[session.state] {
+		allowed = allowed || state == next
+	}
+	if !allowed {
+		return fmt.Errorf("session %d can't change from %s to %s", session.id, session.state, next)
+	}
+	switch next {
+	case SESSION_STATE_PENDING:
+		session.published = false
+	case SESSION_STATE_LEASESET_PUBLISHED:
+		session.published = true
+	}
+	if session.state != next {
+		Debug(SESSION, "Session %d changed from %s to %s", session.id, session.state, next)
+	}
+	session.state = next
+	if session.stateChanged != nil {
+		close(session.stateChanged)
+		session.stateChanged = nil
+	}
+	return nil
+}
+
+// setSessionState moves sess to next.
+func (c *Client) setSessionState(sess *Session, next SessionState) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return sess.setStateLocked(next)
+}
@@ -1 +1,344 @@
 package go_i2cp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/wkoomson/go-i2cp/message"
+)
+
+func TestSession_Reconfigure(t *testing.T) {
+	router, client := startRouter(t)
+	reconfigures := make(chan []byte, 4)
+	router.OnReceive = func(typ uint8, body []byte) {
+		if typ == I2CP_MSG_RECONFIGURE_SESSION {
+			reconfigures <- body
+		}
+	}
+	options := make(chan map[string]string, 4)
+	router.Reconfigure = func(sessionId uint16, opts map[string]string) bool {
+		options <- opts
+		return opts["inbound.quantity"] != "17"
+	}
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Could not connect: %s", err.Error())
+	}
+	defer client.Disconnect()
+	statuses := make(chan SessionStatus, 4)
+	session, _ := NewSession(client, SessionCallbacks{
+		onStatus: func(session *Session, status SessionStatus) { statuses <- status },
+	})
+	session.config.SetProperty(SESSION_CONFIG_PROP_INBOUND_NICKNAME, "before")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.CreateSessionContext(ctx, session); err != nil {
+		t.Fatalf("Could not create session: %s", err.Error())
+	}
+	<-statuses
+
+	err := session.Reconfigure(ctx, map[SessionConfigProperty]string{
+		SESSION_CONFIG_PROP_INBOUND_QUANTITY: "4",
+		SESSION_CONFIG_PROP_INBOUND_NICKNAME: "",
+	})
+	if err != nil {
+		t.Fatalf("Could not reconfigure: %s", err.Error())
+	}
+	// the signature covers the config after the session id
+	if verified, _ := GetCryptoInstance().VerifyStream(&session.config.destination.sgk, NewStream((<-reconfigures)[2:])); !verified {
+		t.Fatal("ReconfigureSession signature did not verify")
+	}
+	if opts := <-options; opts["inbound.quantity"] != "4" || opts["inbound.nickname"] != "" {
+		t.Fatalf("Unexpected options sent to the router: %v", opts)
+	}
+	if status := <-statuses; status != I2CP_SESSION_STATUS_UPDATED {
+		t.Fatalf("Expected status updated, got %s", status)
+	}
+
+	err = session.Reconfigure(ctx, map[SessionConfigProperty]string{SESSION_CONFIG_PROP_INBOUND_QUANTITY: "17"})
+	if !errors.Is(err, ErrReconfigureRefused) {
+		t.Fatalf("Expected ErrReconfigureRefused, got %v", err)
+	}
+	if quantity := session.config.properties[SESSION_CONFIG_PROP_INBOUND_QUANTITY]; quantity != "4" {
+		t.Fatalf("Expected the refused change to be rolled back, got quantity %s", quantity)
+	}
+}
+
+func TestSession_ReconfigureReconnect(t *testing.T) {
+	router, client := startRouter(t)
+	creates := make(chan []byte, 4)
+	router.OnReceive = func(typ uint8, body []byte) {
+		switch typ {
+		case I2CP_MSG_CREATE_SESSION:
+			creates <- body
+		case I2CP_MSG_RECONFIGURE_SESSION:
+			// the router goes away before answering
+			router.Disconnect("router restart")
+		}
+	}
+	client.SetReconnectPolicy(&ReconnectPolicy{InitialDelay: 10 * time.Millisecond, MaxDelay: 10 * time.Millisecond, Multiplier: 1, MaxAttempts: 5})
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Could not connect: %s", err.Error())
+	}
+	defer client.Disconnect()
+	session, _ := NewSession(client, SessionCallbacks{})
+	if err := client.CreateSession(session); err != nil {
+		t.Fatalf("Could not create session: %s", err.Error())
+	}
+	<-creates
+	result := make(chan error, 1)
+	go func() {
+		result <- session.Reconfigure(context.Background(), map[SessionConfigProperty]string{SESSION_CONFIG_PROP_INBOUND_QUANTITY: "4"})
+	}()
+	select {
+	case err := <-result:
+		if !errors.Is(err, ErrRouterDisconnected) {
+			t.Fatalf("Expected ErrRouterDisconnected, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Reconfigure did not return after the connection was lost")
+	}
+	// the restored session and the local config agree on the new options
+	var m message.CreateSession
+	select {
+	case body := <-creates:
+		if err := m.Unmarshal(body); err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Session was not restored")
+	}
+	if m.Options["inbound.quantity"] != "4" {
+		t.Fatalf("Expected the session to be restored with the new config, got %v", m.Options)
+	}
+	client.lock.Lock()
+	quantity := session.config.properties[SESSION_CONFIG_PROP_INBOUND_QUANTITY]
+	client.lock.Unlock()
+	if quantity != "4" {
+		t.Fatalf("Expected the new config to be kept, got quantity %s", quantity)
+	}
+}
+
+func TestSessionConfig_Signed(t *testing.T) {
+	dest, err := NewDestination()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &SessionConfig{destination: dest}
+	config.SetProperty(SESSION_CONFIG_PROP_OUTBOUND_NICKNAME, "test")
+	m := message.CreateSession{}
+	if m.SessionConfig, err = config.toMessage(time.Now(), nil); err != nil {
+		t.Fatal(err)
+	}
+	body, err := m.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Signature) != 40 {
+		t.Fatalf("Expected a 40 bytes DSA signature, got %d bytes", len(m.Signature))
+	}
+	if verified, _ := GetCryptoInstance().VerifyStream(&dest.sgk, NewStream(body)); !verified {
+		t.Fatal("Session config signature did not verify")
+	}
+}
+
+func TestClient_CreateSessionOmitsPrivateKey(t *testing.T) {
+	router, client := startRouter(t)
+	bodies := make(chan []byte, 1)
+	router.OnReceive = func(typ uint8, body []byte) {
+		if typ == I2CP_MSG_CREATE_SESSION {
+			bodies <- body
+		}
+	}
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Could not connect: %s", err.Error())
+	}
+	defer client.Disconnect()
+	session, _ := NewSession(client, SessionCallbacks{})
+	if err := client.CreateSession(session); err != nil {
+		t.Fatalf("Could not create session: %s", err.Error())
+	}
+	private := session.config.destination.sgk.priv.X.FillBytes(make([]byte, 20))
+	if bytes.Contains(<-bodies, private) {
+		t.Fatal("CreateSession contains the private signing key")
+	}
+}
+
+func TestSession_CreateSubsession(t *testing.T) {
+	router, client := startRouter(t)
+	leaseSets := make(chan uint16, 4)
+	router.OnReceive = func(typ uint8, body []byte) {
+		if typ == I2CP_MSG_CREATE_LEASE_SET {
+			leaseSets <- uint16(body[0])<<8 | uint16(body[1])
+		}
+	}
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Could not connect: %s", err.Error())
+	}
+	defer client.Disconnect()
+	received := make(chan *Session, 1)
+	destroyed := make(chan *Session, 4)
+	primary, _ := NewSession(client, SessionCallbacks{
+		onMessage: func(session *Session, protocol uint8, srcPort, destPort uint16, payload *Stream) {
+			received <- session
+		},
+		onStatus: func(session *Session, status SessionStatus) {
+			if status == I2CP_SESSION_STATUS_DESTROYED {
+				destroyed <- session
+			}
+		},
+	})
+	primary.config.SetProperty(SESSION_CONFIG_PROP_INBOUND_QUANTITY, "3")
+	if err := client.CreateSession(primary); err != nil {
+		t.Fatalf("Could not create session: %s", err.Error())
+	}
+	config := &SessionConfig{}
+	config.SetProperty(SESSION_CONFIG_PROP_INBOUND_QUANTITY, "6")
+	config.SetProperty(SESSION_CONFIG_PROP_OUTBOUND_NICKNAME, "sub")
+	sub, err := primary.CreateSubsession(nil, config)
+	if err != nil {
+		t.Fatalf("Could not create subsession: %s", err.Error())
+	}
+	if sub.Primary() != primary || len(primary.Subsessions()) != 1 || sub.id == primary.id {
+		t.Fatalf("Subsession %d is not linked to primary session %d", sub.id, primary.id)
+	}
+	if quantity := sub.config.properties[SESSION_CONFIG_PROP_INBOUND_QUANTITY]; quantity != "3" {
+		t.Fatalf("Expected the primary's tunnel options, got inbound.quantity %s", quantity)
+	}
+	if _, err = sub.CreateSubsession(nil, nil); err == nil {
+		t.Fatal("Expected an error creating a subsession of a subsession")
+	}
+	// every session signs its own lease set
+	signed := map[uint16]bool{<-leaseSets: true, <-leaseSets: true}
+	if !signed[primary.id] || !signed[sub.id] {
+		t.Fatalf("Expected lease sets for sessions %d and %d, got %v", primary.id, sub.id, signed)
+	}
+
+	if err = primary.SendMessage(sub.Destination(), PROTOCOL_DATAGRAM, 0, 0, NewStream([]byte("hello")), 1); err != nil {
+		t.Fatalf("Could not send message: %s", err.Error())
+	}
+	select {
+	case session := <-received:
+		if session != sub {
+			t.Fatalf("Message was delivered to session %d instead of subsession %d", session.id, sub.id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Subsession did not receive the message")
+	}
+
+	if err = primary.Close(); err != nil {
+		t.Fatalf("Could not destroy primary session: %s", err.Error())
+	}
+	if first, second := <-destroyed, <-destroyed; first != sub || second != primary {
+		t.Fatal("Expected the subsession to be destroyed before its primary")
+	}
+	if len(client.sessions) != 0 || len(primary.Subsessions()) != 0 {
+		t.Fatalf("Expected no sessions after destroying the primary, got %d", len(client.sessions))
+	}
+}
+
+func TestSession_CreateSubsessionUnsupported(t *testing.T) {
+	router, client := startRouter(t)
+	router.Version = "0.9.20"
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Could not connect: %s", err.Error())
+	}
+	defer client.Disconnect()
+	primary, _ := NewSession(client, SessionCallbacks{})
+	if err := client.CreateSession(primary); err != nil {
+		t.Fatalf("Could not create session: %s", err.Error())
+	}
+	if _, err := primary.CreateSubsession(nil, nil); !errors.Is(err, ErrSubsessionsUnsupported) {
+		t.Fatalf("Expected ErrSubsessionsUnsupported, got %v", err)
+	}
+}
+
+func TestSession_State(t *testing.T) {
+	router, client := startRouter(t)
+	router.Reconfigure = func(sessionId uint16, opts map[string]string) bool {
+		return opts["inbound.quantity"] != "17"
+	}
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Could not connect: %s", err.Error())
+	}
+	defer client.Disconnect()
+	session, _ := NewSession(client, SessionCallbacks{})
+	if state := session.State(); state != SESSION_STATE_PENDING {
+		t.Fatalf("Expected a new session to be pending, got %s", state)
+	}
+	err := session.SendMessage(session.Destination(), PROTOCOL_DATAGRAM, 0, 0, NewStream([]byte("early")), 0)
+	var stateErr *SessionStateError
+	if !errors.As(err, &stateErr) || !errors.Is(err, ErrSessionNotUsable) || stateErr.State != SESSION_STATE_PENDING {
+		t.Fatalf("Expected a SessionStateError for a pending session, got %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ready := make(chan error, 1)
+	go func() { ready <- session.WaitReady(ctx) }()
+	if err = client.CreateSessionContext(ctx, session); err != nil {
+		t.Fatalf("Could not create session: %s", err.Error())
+	}
+	if err = <-ready; err != nil {
+		t.Fatalf("WaitReady failed: %s", err.Error())
+	}
+	if state := session.State(); state != SESSION_STATE_LEASESET_PUBLISHED {
+		t.Fatalf("Expected the lease set to be published, got %s", state)
+	}
+
+	if err = session.Reconfigure(ctx, map[SessionConfigProperty]string{SESSION_CONFIG_PROP_INBOUND_QUANTITY: "4"}); err != nil {
+		t.Fatalf("Could not reconfigure: %s", err.Error())
+	}
+	if state := session.State(); state != SESSION_STATE_UPDATED {
+		t.Fatalf("Expected the session to be updated, got %s", state)
+	}
+	if err = session.Reconfigure(ctx, map[SessionConfigProperty]string{SESSION_CONFIG_PROP_INBOUND_QUANTITY: "17"}); !errors.Is(err, ErrReconfigureRefused) {
+		t.Fatalf("Expected ErrReconfigureRefused, got %v", err)
+	}
+	// a refused reconfiguration doesn't invalidate the session
+	if state := session.State(); state != SESSION_STATE_UPDATED {
+		t.Fatalf("Expected the session to stay updated, got %s", state)
+	}
+	if err = session.WaitReady(ctx); err != nil {
+		t.Fatalf("Expected an updated session with a lease set to be ready, got %v", err)
+	}
+
+	if err = session.Close(); err != nil {
+		t.Fatalf("Could not destroy session: %s", err.Error())
+	}
+	if state := session.State(); state != SESSION_STATE_DESTROYED {
+		t.Fatalf("Expected the session to be destroyed, got %s", state)
+	}
+	if err = session.WaitReady(ctx); !errors.Is(err, ErrSessionNotUsable) {
+		t.Fatalf("Expected WaitReady to fail for a destroyed session, got %v", err)
+	}
+	err = session.SendMessage(session.Destination(), PROTOCOL_DATAGRAM, 0, 0, NewStream([]byte("late")), 0)
+	if !errors.As(err, &stateErr) || stateErr.State != SESSION_STATE_DESTROYED {
+		t.Fatalf("Expected a SessionStateError for a destroyed session, got %v", err)
+	}
+}
+
+func TestSessionState_Transitions(t *testing.T) {
+	session, _ := NewSession(NewClient(nil), SessionCallbacks{})
+	for _, step := range []struct {
+		next SessionState
+		ok   bool
+	}{
+		{SESSION_STATE_LEASESET_PUBLISHED, false},
+		{SESSION_STATE_CREATED, true},
+		{SESSION_STATE_UPDATED, true},
+		{SESSION_STATE_LEASESET_PUBLISHED, true},
+		{SESSION_STATE_DESTROYED, true},
+		{SESSION_STATE_CREATED, false},
+		{SESSION_STATE_PENDING, true},
+		{SESSION_STATE_INVALID, true},
+		{SESSION_STATE_UPDATED, false},
+	} {
+		from := session.state
+		if err := session.client.setSessionState(session, step.next); (err == nil) != step.ok {
+			t.Fatalf("Transition from %s to %s: expected ok=%t, got %v", from, step.next, step.ok, err)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package go_i2cp
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimit configures a token bucket for outgoing messages. A zero rate is
+// unlimited. Set it with Client.SetRateLimit or Session.SetRateLimit.
+type RateLimit struct {
+	// BytesPerSecond limits the size of the SendMessage frames.
+	BytesPerSecond int64
+	// BurstBytes is the number of bytes that can be sent at once after an
+	// idle period, one second worth of BytesPerSecond if 0.
+	BurstBytes        int64
+	MessagesPerSecond float64
+	// BurstMessages is the number of messages that can be sent at once after
+	// an idle period, one second worth of MessagesPerSecond if 0.
+	BurstMessages int
+}
+
+// RateLimitFromBandwidthLimits returns a RateLimit for the client outbound
+// limit of the router with a burst of one second.
+func RateLimitFromBandwidthLimits(limits BandwidthLimits) *RateLimit {
+	return &RateLimit{BytesPerSecond: limits.ClientOutboundBytes()}
+}
+
+// SetRateLimit limits the messages sent by all sessions of the client
+// together, nil removes the limit.
+func (c *Client) SetRateLimit(limit *RateLimit) {
+	c.lock.Lock()
+	c.rateLimiter = newRateLimiter(limit)
+	c.lock.Unlock()
+}
+
+// SetRateLimitFromRouter asks the router for its bandwidth limits and limits
+// the client to its client outbound rate.
+func (c *Client) SetRateLimitFromRouter(ctx context.Context) error {
+	limits, err := c.BandwidthLimits(ctx)
+	if err != nil {
+		return err
+	}
+	c.SetRateLimit(RateLimitFromBandwidthLimits(limits))
+	return nil
+}
+
+// SetRateLimit limits the messages sent by the session, on top of the
+// client's limit. nil removes the limit.
+func (session *Session) SetRateLimit(limit *RateLimit) {
+	session.client.lock.Lock()
+	session.rateLimiter = newRateLimiter(limit)
+	session.client.lock.Unlock()
+}
+
+// tokenBucket holds up to burst tokens and gains rate tokens per second.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket returns a full bucket, a burst of 0 holds one second of
+// tokens.
+func newTokenBucket(rate, burst float64) tokenBucket {
+	if burst <= 0 {
+		burst = rate
+	}
+	return tokenBucket{rate: rate, burst: burst, tokens: burst}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+}
+
+// delay returns the time until n tokens can be taken. More than burst tokens
+// can be taken from a full bucket, it goes into debt then.
+func (b *tokenBucket) delay(n float64, now time.Time) time.Duration {
+	if b.rate == 0 {
+		return 0
+	}
+	b.refill(now)
+	if n > b.burst {
+		n = b.burst
+	}
+	if b.tokens >= n {
+		return 0
+	}
+	return time.Duration((n - b.tokens) / b.rate * float64(time.Second))
+}
+
+func (b *tokenBucket) take(n float64) {
+	if b.rate != 0 {
+		b.tokens -= n
+	}
+}
+
+// give returns n taken tokens, up to burst.
+func (b *tokenBucket) give(n float64) {
+	if b.rate != 0 {
+		b.tokens += n
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+}
+
+// rateLimiter limits bytes and messages with a token bucket each.
+type rateLimiter struct {
+	lock     sync.Mutex
+	bytes    tokenBucket
+	messages tokenBucket
+}
+
+func newRateLimiter(limit *RateLimit) *rateLimiter {
+	if limit == nil {
+		return nil
+	}
+	messages := newTokenBucket(limit.MessagesPerSecond, float64(limit.BurstMessages))
+	if messages.burst < 1 {
+		messages.burst, messages.tokens = 1, 1
+	}
+	return &rateLimiter{
+		bytes:    newTokenBucket(float64(limit.BytesPerSecond), float64(limit.BurstBytes)),
+		messages: messages,
+	}
+}
+
+// reserve takes size bytes and one message from every limiter if all of them
+// have enough tokens, or returns how long to wait before trying again.
+func reserve(limiters []*rateLimiter, size int, now time.Time) time.Duration {
+	for _, l := range limiters {
+		l.lock.Lock()
+		defer l.lock.Unlock()
+	}
+	var wait time.Duration
+	for _, l := range limiters {
+		if d := l.bytes.delay(float64(size), now); d > wait {
+			wait = d
+		}
+		if d := l.messages.delay(1, now); d > wait {
+			wait = d
+		}
+	}
+	if wait > 0 {
+		return wait
+	}
+	for _, l := range limiters {
+		l.bytes.take(float64(size))
+		l.messages.take(1)
+	}
+	return 0
+}
+
+// release returns the tokens of a reservation whose frame wasn't sent.
+func release(limiters []*rateLimiter, size int) {
+	for _, l := range limiters {
+		l.lock.Lock()
+		l.bytes.give(float64(size))
+		l.messages.give(1)
+		l.lock.Unlock()
+	}
+}
+
+// shape applies the session's and the client's rate limits to a frame of
+// size bytes. It fails with ErrRateLimited, or if block is set waits for
+// tokens until ctx is done. The returned func gives the tokens back when the
+// frame could not be queued after all.
+func (c *Client) shape(ctx context.Context, sess *Session, size int, block bool) (func(), error) {
+	c.lock.Lock()
+	// reserve locks them in this order, session before client
+	limiters := make([]*rateLimiter, 0, 2)
+	for _, l := range []*rateLimiter{sess.rateLimiter, c.rateLimiter} {
+		if l != nil {
+			limiters = append(limiters, l)
+		}
+	}
+	c.lock.Unlock()
+	if len(limiters) == 0 {
+		return func() {}, nil
+	}
+	for {
+		wait := reserve(limiters, size, time.Now())
+		if wait == 0 {
+			return func() { release(limiters, size) }, nil
+		}
+		if !block {
+			return nil, ErrRateLimited
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package go_i2cp
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	b := newTokenBucket(100, 200)
+	if d := b.delay(200, now); d != 0 {
+		t.Fatalf("Expected a full bucket, got a delay of %s", d)
+	}
+	b.take(200)
+	if d := b.delay(50, now); d != 500*time.Millisecond {
+		t.Fatalf("Expected a delay of 500ms, got %s", d)
+	}
+	if d := b.delay(50, now.Add(500*time.Millisecond)); d != 0 {
+		t.Fatalf("Expected 50 tokens after 500ms, got a delay of %s", d)
+	}
+	// more than burst waits for a full bucket and leaves a debt
+	if d := b.delay(1000, now.Add(2500*time.Millisecond)); d != 0 {
+		t.Fatalf("Expected a full bucket after 2.5s, got a delay of %s", d)
+	}
+	b.take(1000)
+	if d := b.delay(1, now.Add(2500*time.Millisecond)); d != 8010*time.Millisecond {
+		t.Fatalf("Expected a delay of 8.01s, got %s", d)
+	}
+}
+
+func TestSession_RateLimit(t *testing.T) {
+	client := NewClient(nil)
+	session, err := NewSession(client, SessionCallbacks{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// there is no router, pretend it created the session
+	session.state = SESSION_STATE_CREATED
+	session.SetRateLimit(&RateLimit{MessagesPerSecond: 20, BurstMessages: 2})
+	send := func() error {
+		return session.SendMessage(session.Destination(), PROTOCOL_DATAGRAM, 0, 0, NewStream([]byte("payload")), 0)
+	}
+	for i := 0; i < 2; i++ {
+		if err = send(); err != nil {
+			t.Fatalf("Could not send message %d of the burst: %s", i, err.Error())
+		}
+	}
+	if err = send(); err != ErrRateLimited {
+		t.Fatalf("Expected ErrRateLimited, got %v", err)
+	}
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err = session.SendMessageContext(ctx, session.Destination(), PROTOCOL_DATAGRAM, 0, 0, NewStream([]byte("payload")), 0); err != nil {
+		t.Fatalf("Could not send message: %s", err.Error())
+	}
+	if elapsed := time.Since(start); elapsed < 25*time.Millisecond {
+		t.Fatalf("Expected SendMessageContext to wait for a token, it took %s", elapsed)
+	}
+
+	// the client limit applies to all sessions
+	session.SetRateLimit(nil)
+	client.SetRateLimit(&RateLimit{BytesPerSecond: 1})
+	if err = send(); err != nil {
+		t.Fatalf("Could not send the first message: %s", err.Error())
+	}
+	if err = send(); err != ErrRateLimited {
+		t.Fatalf("Expected ErrRateLimited, got %v", err)
+	}
+}
+
+func TestSession_RateLimitRefund(t *testing.T) {
+	client := NewClient(nil)
+	client.SetQueueConfig(&QueueConfig{Capacity: 1})
+	session, err := NewSession(client, SessionCallbacks{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// there is no router, pretend it created the session
+	session.state = SESSION_STATE_CREATED
+	session.SetRateLimit(&RateLimit{MessagesPerSecond: 0.001, BurstMessages: 2})
+	send := func() error {
+		return session.SendMessage(session.Destination(), PROTOCOL_DATAGRAM, 0, 0, NewStream([]byte("payload")), 0)
+	}
+	if err = send(); err != nil {
+		t.Fatalf("Could not send the first message: %s", err.Error())
+	}
+	if err = send(); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("Expected ErrQueueFull, got %v", err)
+	}
+	client.SetTransport(&sendCounter{})
+	if err = client.flushOutputQueue(); err != nil {
+		t.Fatal(err)
+	}
+	// the message that didn't fit in the queue was not charged
+	if err = send(); err != nil {
+		t.Fatalf("Expected the refused message to be refunded, got %v", err)
+	}
+}
+
+func TestClient_SetRateLimitFromRouter(t *testing.T) {
+	router, client := startRouter(t)
+	router.BandwidthLimits.ClientOutbound = 64
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.ConnectContext(ctx); err != nil {
+		t.Fatalf("Could not connect: %s", err.Error())
+	}
+	defer client.Disconnect()
+	if err := client.SetRateLimitFromRouter(ctx); err != nil {
+		t.Fatalf("Could not set the rate limit: %s", err.Error())
+	}
+	if rate := client.rateLimiter.bytes.rate; rate != 64*1024 {
+		t.Fatalf("Expected a rate of %d bytes per second, got %f", 64*1024, rate)
+	}
+}
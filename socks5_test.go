@@ -0,0 +1,150 @@
+package go_i2cp
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+// startSOCKS5 serves a minimal SOCKS5 proxy that requires user/password if
+// user is set and reports the addresses it was asked to connect to.
+func startSOCKS5(t *testing.T, user, password string) (net.Listener, chan string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	requested := make(chan string, 8)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveSOCKS5(conn, user, password, requested)
+		}
+	}()
+	return ln, requested
+}
+
+func serveSOCKS5(conn net.Conn, user, password string, requested chan string) {
+	defer conn.Close()
+	buf := make([]byte, 512)
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return
+	}
+	methods := buf[2 : 2+buf[1]]
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return
+	}
+	method := byte(socks5AuthNoMethod)
+	for _, m := range methods {
+		if (user == "" && m == socks5AuthNone) || (user != "" && m == socks5AuthPassword) {
+			method = m
+		}
+	}
+	conn.Write([]byte{socks5Version, method})
+	if method == socks5AuthNoMethod {
+		return
+	}
+	if method == socks5AuthPassword {
+		io.ReadFull(conn, buf[:2])
+		u := make([]byte, buf[1])
+		io.ReadFull(conn, u)
+		io.ReadFull(conn, buf[:1])
+		p := make([]byte, buf[0])
+		io.ReadFull(conn, p)
+		if string(u) != user || string(p) != password {
+			conn.Write([]byte{1, 1})
+			return
+		}
+		conn.Write([]byte{1, 0})
+	}
+	if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+		return
+	}
+	var host string
+	switch buf[3] {
+	case socks5AddrIPv4:
+		io.ReadFull(conn, buf[:4])
+		host = net.IP(buf[:4]).String()
+	case socks5AddrDomain:
+		io.ReadFull(conn, buf[:1])
+		name := make([]byte, buf[0])
+		io.ReadFull(conn, name)
+		host = string(name)
+	}
+	io.ReadFull(conn, buf[:2])
+	address := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(buf[:2]))))
+	requested <- address
+	target, err := net.Dial("tcp", address)
+	if err != nil {
+		conn.Write([]byte{socks5Version, 5, 0, socks5AddrIPv4, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer target.Close()
+	conn.Write([]byte{socks5Version, 0, 0, socks5AddrIPv4, 127, 0, 0, 1, 0, 0})
+	go io.Copy(target, conn)
+	io.Copy(conn, target)
+}
+
+func TestClient_ConnectSOCKS5(t *testing.T) {
+	_, client := startRouter(t)
+	proxy, requested := startSOCKS5(t, "alice", "secret")
+	routerAddress := net.JoinHostPort(client.tcp.GetProperty(TCP_PROP_ADDRESS), client.tcp.GetProperty(TCP_PROP_PORT))
+	client.SetProperty("i2cp.tcp.proxy", "socks5://alice:secret@"+proxy.Addr().String())
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Could not connect through proxy: %s", err.Error())
+	}
+	defer client.Disconnect()
+	if address := <-requested; address != routerAddress {
+		t.Fatalf("Expected the proxy to connect to %s, got %s", routerAddress, address)
+	}
+	session, _ := NewSession(client, SessionCallbacks{})
+	if err := client.CreateSession(session); err != nil {
+		t.Fatalf("Could not create session through proxy: %s", err.Error())
+	}
+}
+
+func TestSOCKS5_Errors(t *testing.T) {
+	proxy, _ := startSOCKS5(t, "alice", "secret")
+	ctx := context.Background()
+	dialer := SOCKS5(proxy.Addr().String(), &SOCKS5Auth{User: "alice", Password: "wrong"}, nil)
+	_, err := dialer.(ContextDialer).DialContext(ctx, "tcp", "router.i2p:7654")
+	var proxyErr *ProxyError
+	if !errors.As(err, &proxyErr) || !errors.Is(err, ErrProxyAuth) {
+		t.Fatalf("Expected a ProxyError for ErrProxyAuth, got %v", err)
+	}
+	if _, err = SOCKS5(proxy.Addr().String(), nil, nil).Dial("tcp", "router.i2p:7654"); !errors.Is(err, ErrProxyAuth) {
+		t.Fatalf("Expected ErrProxyAuth without credentials, got %v", err)
+	}
+	dead, _ := net.Listen("tcp", "127.0.0.1:0")
+	dead.Close()
+	_, err = SOCKS5(proxy.Addr().String(), &SOCKS5Auth{User: "alice", Password: "secret"}, nil).Dial("tcp", dead.Addr().String())
+	var replyErr *SOCKS5ReplyError
+	if !errors.As(err, &replyErr) || replyErr.Reply != 5 {
+		t.Fatalf("Expected connection refused by the proxy, got %v", err)
+	}
+}
+
+func TestClient_SetDialer(t *testing.T) {
+	_, client := startRouter(t)
+	var dials atomic.Int32
+	client.SetDialer(DialFunc(func(ctx context.Context, network, address string) (net.Conn, error) {
+		dials.Add(1)
+		var d net.Dialer
+		return d.DialContext(ctx, network, address)
+	}))
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Could not connect with custom dialer: %s", err.Error())
+	}
+	defer client.Disconnect()
+	if n := dials.Load(); n != 1 {
+		t.Fatalf("Expected the custom dialer to be used once, got %d", n)
+	}
+}
@@ -3,6 +3,8 @@ package go_i2cp
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
+	"io"
 	"os"
 	"sort"
 )
@@ -16,19 +18,25 @@ func NewStream(buf []byte) (s *Stream) {
 }
 func (s *Stream) ReadUint16() (r uint16, err error) {
 	bts := make([]byte, 2)
-	_, err = s.Read(bts)
+	if _, err = io.ReadFull(s, bts); err != nil {
+		return
+	}
 	r = binary.BigEndian.Uint16(bts)
 	return
 }
 func (s *Stream) ReadUint32() (r uint32, err error) {
 	bts := make([]byte, 4)
-	_, err = s.Read(bts)
+	if _, err = io.ReadFull(s, bts); err != nil {
+		return
+	}
 	r = binary.BigEndian.Uint32(bts)
 	return
 }
 func (s *Stream) ReadUint64() (r uint64, err error) {
 	bts := make([]byte, 8)
-	_, err = s.Read(bts)
+	if _, err = io.ReadFull(s, bts); err != nil {
+		return
+	}
 	r = binary.BigEndian.Uint64(bts)
 	return
 }
@@ -53,11 +61,28 @@ func (s *Stream) WriteUint64(i uint64) (err error) {
 }
 
 func (stream *Stream) WriteLenPrefixedString(s string) (err error) {
-	err = stream.WriteByte(uint8(len(s)))
+	if len(s) > 255 {
+		return fmt.Errorf("i2cp: string of %d bytes exceeds the 255 bytes limit", len(s))
+	}
+	stream.WriteByte(uint8(len(s)))
 	_, err = stream.WriteString(s)
 	return
 }
 
+// ReadLenPrefixedString reads an I2CP String, a length byte followed by up
+// to 255 bytes of UTF-8.
+func (stream *Stream) ReadLenPrefixedString() (s string, err error) {
+	var length uint8
+	if length, err = stream.ReadByte(); err != nil {
+		return
+	}
+	buf := make([]byte, length)
+	if _, err = io.ReadFull(stream, buf); err != nil {
+		return
+	}
+	return string(buf), nil
+}
+
 func (stream *Stream) WriteMapping(m map[string]string) (err error) {
 	buf := NewStream(make([]byte, 0))
 	keys := make([]string, len(m))
@@ -69,9 +94,13 @@ func (stream *Stream) WriteMapping(m map[string]string) (err error) {
 		if key == "" {
 			continue
 		}
-		buf.WriteLenPrefixedString(key)
+		if err = buf.WriteLenPrefixedString(key); err != nil {
+			return
+		}
 		buf.WriteByte(byte('='))
-		buf.WriteLenPrefixedString(m[key])
+		if err = buf.WriteLenPrefixedString(m[key]); err != nil {
+			return
+		}
 		buf.WriteByte(byte(';'))
 	}
 	err = stream.WriteUint16(uint16(buf.Len()))
@@ -80,7 +109,7 @@ func (stream *Stream) WriteMapping(m map[string]string) (err error) {
 }
 
 func (s *Stream) loadFile(f *os.File) (err error) {
-	_, err = f.Read(s.Bytes())
+	_, err = s.ReadFrom(f)
 	return
 }
 
@@ -0,0 +1,96 @@
+package go_i2cp
+
+import (
+	"context"
+	"fmt"
+)
+
+// CreateSubsession creates a session for dest that shares the tunnels of
+// session, the primary session. A nil dest generates a new destination, a
+// nil config starts from an empty config.
+func (session *Session) CreateSubsession(dest *Destination, config *SessionConfig) (*Session, error) {
+	return session.CreateSubsessionContext(context.Background(), dest, config)
+}
+
+// CreateSubsessionContext is CreateSubsession bounded by ctx. The router must
+// support subsessions and session must be a created primary session. The
+// tunnel options of the primary are used, the router ignores the ones of a
+// subsession. The subsession gets the primary's callbacks, the router sends
+// it its own messages and lease set requests, and it is destroyed with the
+// primary.
+func (session *Session) CreateSubsessionContext(ctx context.Context, dest *Destination, config *SessionConfig) (*Session, error) {
+	c := session.client
+	if !c.IsConnected() {
+		return nil, ErrNotConnected
+	}
+	if !c.RouterInfo().Supports(ROUTER_CAN_SUBSESSIONS) {
+		return nil, ErrSubsessionsUnsupported
+	}
+	c.lock.Lock()
+	created := c.sessions[session.id] == session
+	primary := session.primary
+	tunnels := session.config.properties
+	c.lock.Unlock()
+	if !created {
+		return nil, &UnknownSessionError{SessionId: c.sessionId(session), Type: I2CP_MSG_CREATE_SESSION}
+	}
+	if primary != nil {
+		return nil, fmt.Errorf("i2cp: session %d is a subsession, subsessions are created on the primary session", c.sessionId(session))
+	}
+	if dest == nil {
+		var err error
+		if dest, err = NewDestination(); err != nil {
+			return nil, err
+		}
+	}
+	if dest.b32 == session.config.destination.b32 {
+		return nil, fmt.Errorf("i2cp: subsession must not use the destination of its primary session")
+	}
+	sub := &Session{client: c, primary: session, callbacks: session.callbacks, config: &SessionConfig{}}
+	if config != nil {
+		*sub.config = *config
+	}
+	sub.config.destination = dest
+	for prop := SESSION_CONFIG_PROP_INBOUND_ALLOW_ZERO_HOP; prop <= SESSION_CONFIG_PROP_OUTBOUND_QUANTITY; prop++ {
+		sub.config.properties[prop] = tunnels[prop]
+	}
+	if err := c.CreateSessionContext(ctx, sub); err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+// Primary returns the session whose tunnels a subsession shares, nil for a
+// primary session.
+func (session *Session) Primary() *Session {
+	return session.primary
+}
+
+// Subsessions returns the created subsessions of a primary session.
+func (session *Session) Subsessions() []*Session {
+	c := session.client
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]*Session(nil), session.subsessions...)
+}
+
+// addSubsession registers a created subsession with its primary, c.lock must
+// be held. A restored subsession is already registered.
+func (session *Session) addSubsession(sub *Session) {
+	for _, s := range session.subsessions {
+		if s == sub {
+			return
+		}
+	}
+	session.subsessions = append(session.subsessions, sub)
+}
+
+// removeSubsession unregisters a destroyed subsession, c.lock must be held.
+func (session *Session) removeSubsession(sub *Session) {
+	for i, s := range session.subsessions {
+		if s == sub {
+			session.subsessions = append(session.subsessions[:i], session.subsessions[i+1:]...)
+			return
+		}
+	}
+}
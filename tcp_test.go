@@ -0,0 +1,165 @@
+package go_i2cp
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+func selfSignedCertificate(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Could not generate key: %s", err.Error())
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "i2cp test router"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Could not create certificate: %s", err.Error())
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func startTLSRouter(t *testing.T, cert tls.Certificate) net.Listener {
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatalf("Could not listen: %s", err.Error())
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				buf := make([]byte, 1)
+				if _, err := conn.Read(buf); err == nil {
+					conn.Write(buf)
+				}
+			}()
+		}
+	}()
+	return ln
+}
+
+func tcpTo(addr net.Addr) *Tcp {
+	var tcp Tcp
+	host, port, _ := net.SplitHostPort(addr.String())
+	tcp.SetProperty(TCP_PROP_ADDRESS, host)
+	tcp.SetProperty(TCP_PROP_PORT, port)
+	return &tcp
+}
+
+func TestTcp_ConnectTLSPinned(t *testing.T) {
+	cert := selfSignedCertificate(t)
+	ln := startTLSRouter(t, cert)
+	defer ln.Close()
+	sum := sha256.Sum256(cert.Certificate[0])
+
+	tcp := tcpTo(ln.Addr())
+	tcp.SetProperty(TCP_PROP_USE_TLS, "true")
+	tcp.SetProperty(TCP_PROP_TLS_PINNED_FINGERPRINT, hex.EncodeToString(sum[:]))
+	if err := tcp.Connect(); err != nil {
+		t.Fatalf("Could not connect to pinned tls router: %s", err.Error())
+	}
+	defer tcp.Disconnect()
+	if _, err := tcp.Send(NewStream([]byte{I2CP_PROTOCOL_INIT})); err != nil {
+		t.Fatalf("Could not send over tls: %s", err.Error())
+	}
+	in := NewStream(make([]byte, 1))
+	if _, err := tcp.Receive(in); err != nil || in.Bytes()[0] != I2CP_PROTOCOL_INIT {
+		t.Fatalf("Did not receive echoed byte over tls, err %v", err)
+	}
+}
+
+func TestTcp_ConnectTLSWrongPin(t *testing.T) {
+	ln := startTLSRouter(t, selfSignedCertificate(t))
+	defer ln.Close()
+
+	tcp := tcpTo(ln.Addr())
+	tcp.SetProperty(TCP_PROP_USE_TLS, "true")
+	tcp.SetProperty(TCP_PROP_TLS_PINNED_FINGERPRINT, hex.EncodeToString(make([]byte, sha256.Size)))
+	err := tcp.Connect()
+	if _, ok := err.(*TLSHandshakeError); !ok {
+		t.Fatalf("Expected a TLSHandshakeError, got %v", err)
+	}
+}
+
+func TestTcp_ConnectTLSUntrusted(t *testing.T) {
+	ln := startTLSRouter(t, selfSignedCertificate(t))
+	defer ln.Close()
+
+	tcp := tcpTo(ln.Addr())
+	tcp.SetProperty(TCP_PROP_USE_TLS, "true")
+	err := tcp.Connect()
+	if _, ok := err.(*TLSHandshakeError); !ok {
+		t.Fatalf("Expected a TLSHandshakeError for an untrusted certificate, got %v", err)
+	}
+}
+
+func TestTcp_Endpoints(t *testing.T) {
+	var tcp Tcp
+	tcp.SetProperty(TCP_PROP_ADDRESS, "::1")
+	tcp.SetProperty(TCP_PROP_PORT, "7655")
+	tcp.SetProperty(TCP_PROP_FALLBACK_ADDRESSES, "router.local:7654, [fe80::1]:7654")
+	endpoints, err := tcp.endpoints()
+	if err != nil {
+		t.Fatalf("Could not list endpoints: %s", err.Error())
+	}
+	expected := []string{"[::1]:7655", "router.local:7654", "[fe80::1]:7654"}
+	if len(endpoints) != len(expected) {
+		t.Fatalf("Expected endpoints %v, got %v", expected, endpoints)
+	}
+	for i := range expected {
+		if endpoints[i] != expected[i] {
+			t.Fatalf("Expected endpoints %v, got %v", expected, endpoints)
+		}
+	}
+	tcp.SetProperty(TCP_PROP_FALLBACK_ADDRESSES, "router.local")
+	if _, err = tcp.endpoints(); err == nil {
+		t.Fatal("Fallback address without port should be rejected")
+	}
+}
+
+func TestTcp_ConnectFallback(t *testing.T) {
+	dead, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead.Close()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		if conn, err := ln.Accept(); err == nil {
+			conn.Close()
+		}
+	}()
+
+	tcp := tcpTo(dead.Addr())
+	tcp.SetProperty(TCP_PROP_FALLBACK_ADDRESSES, ln.Addr().String())
+	if err = tcp.Connect(); err != nil {
+		t.Fatalf("Could not connect to fallback endpoint: %s", err.Error())
+	}
+	defer tcp.Close()
+	if tcp.Endpoint() != ln.Addr().String() {
+		t.Fatalf("Expected endpoint %s, got %s", ln.Addr().String(), tcp.Endpoint())
+	}
+}
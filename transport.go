@@ -0,0 +1,81 @@
+package go_i2cp
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+)
+
+// Transport carries the I2CP byte stream between the client and the router.
+// Tcp is used unless another transport is set with Client.SetTransport.
+type Transport interface {
+	// Connect establishes the connection to the router.
+	Connect() error
+	// Send writes the content of buf to the router.
+	Send(buf *Stream) (int, error)
+	// Receive reads into the bytes of buf.
+	Receive(buf *Stream) (int, error)
+	// IsConnected reports whether the transport is connected and not
+	// closed. It must not read from the connection, the client's reader
+	// goroutine owns it.
+	IsConnected() bool
+	Close() error
+}
+
+// contextConnector is implemented by transports whose Connect can be
+// bounded by a context.
+type contextConnector interface {
+	ConnectContext(ctx context.Context) error
+}
+
+// connectTransport connects t, honoring ctx when the transport supports it.
+func connectTransport(ctx context.Context, t Transport) error {
+	if cc, ok := t.(contextConnector); ok {
+		return cc.ConnectContext(ctx)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return t.Connect()
+}
+
+// connTransport implements the Transport methods shared by all net.Conn
+// based transports.
+type connTransport struct {
+	conn net.Conn
+	open atomic.Bool
+}
+
+// setConn makes conn the connection of the transport.
+func (t *connTransport) setConn(conn net.Conn) {
+	t.conn = conn
+	t.open.Store(true)
+}
+
+func (t *connTransport) Send(buf *Stream) (i int, err error) {
+	if t.conn == nil {
+		return 0, ErrNotConnected
+	}
+	i, err = t.conn.Write(buf.Bytes())
+	return
+}
+
+func (t *connTransport) Receive(buf *Stream) (i int, err error) {
+	if t.conn == nil {
+		return 0, ErrNotConnected
+	}
+	i, err = t.conn.Read(buf.Bytes())
+	return
+}
+
+func (t *connTransport) IsConnected() bool {
+	return t.open.Load()
+}
+
+func (t *connTransport) Close() (err error) {
+	t.open.Store(false)
+	if t.conn != nil {
+		err = t.conn.Close()
+	}
+	return
+}
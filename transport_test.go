@@ -0,0 +1,76 @@
+package go_i2cp
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPipe_SendReceive(t *testing.T) {
+	var transport Transport
+	pipe, router := NewPipe()
+	transport = pipe
+	if err := transport.Connect(); err != nil {
+		t.Fatalf("Could not connect pipe: %s", err.Error())
+	}
+	go func() {
+		buf := make([]byte, 1)
+		router.Read(buf)
+		router.Write([]byte{buf[0] + 1})
+	}()
+	if _, err := transport.Send(NewStream([]byte{I2CP_PROTOCOL_INIT})); err != nil {
+		t.Fatalf("Could not send over pipe: %s", err.Error())
+	}
+	// IsConnected must not consume the reply
+	if !transport.IsConnected() {
+		t.Fatal("Pipe should be connected")
+	}
+	in := NewStream(make([]byte, 1))
+	if _, err := transport.Receive(in); err != nil || in.Bytes()[0] != I2CP_PROTOCOL_INIT+1 {
+		t.Fatalf("Did not receive reply over pipe, err %v", err)
+	}
+	transport.Close()
+	if transport.IsConnected() {
+		t.Fatal("Pipe should not be connected after Close")
+	}
+	if err := transport.Connect(); err == nil {
+		t.Fatal("Reconnecting a closed pipe should fail")
+	}
+}
+
+func TestUnix_SendReceive(t *testing.T) {
+	dir, err := os.MkdirTemp("", "i2cp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "i2cp.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Skipf("Unix sockets not available: %s", err.Error())
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, 1)
+		conn.Read(buf)
+		conn.Write(buf)
+	}()
+	unix := NewUnix(path)
+	if err = unix.Connect(); err != nil {
+		t.Fatalf("Could not connect to unix socket: %s", err.Error())
+	}
+	defer unix.Close()
+	if _, err = unix.Send(NewStream([]byte{I2CP_PROTOCOL_INIT})); err != nil {
+		t.Fatalf("Could not send over unix socket: %s", err.Error())
+	}
+	in := NewStream(make([]byte, 1))
+	if _, err = unix.Receive(in); err != nil || in.Bytes()[0] != I2CP_PROTOCOL_INIT {
+		t.Fatalf("Did not receive echoed byte over unix socket, err %v", err)
+	}
+}
@@ -0,0 +1,32 @@
+package go_i2cp
+
+import (
+	"context"
+	"net"
+)
+
+// Unix is a Transport that connects to a router listening on a Unix domain
+// socket.
+type Unix struct {
+	connTransport
+	path string
+}
+
+func NewUnix(path string) *Unix {
+	return &Unix{path: path}
+}
+
+func (u *Unix) Connect() error {
+	return u.ConnectContext(context.Background())
+}
+
+func (u *Unix) ConnectContext(ctx context.Context) (err error) {
+	var conn net.Conn
+	var dialer net.Dialer
+	if conn, err = dialer.DialContext(ctx, "unix", u.path); err != nil {
+		return
+	}
+	u.setConn(conn)
+	Debug(TCP, "Connected to unix socket %s", u.path)
+	return
+}
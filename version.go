@@ -5,62 +5,109 @@ import (
 	"strings"
 )
 
+// Version is an I2P version like 0.9.33, 0.9.33.1 or 0.9.50-rc1.
 type Version struct {
-	major, minor, micro, qualifier uint16
-	version                        string
+	Major, Minor, Micro uint16
+	// Build is the optional fourth number.
+	Build uint16
+	// Qualifier is the text after a dash, "rc1" for 0.9.50-rc1. A version
+	// with a qualifier is a pre-release of the one without.
+	Qualifier string
+	version   string
 }
 
-func parseVersion(str string) Version {
-	var v = Version{}
-	segments := strings.Split(str, ".")
-	n := len(segments)
-	if n > 0 {
-		i, _ := strconv.Atoi(segments[0])
-		v.major = uint16(i)
+// ParseVersion parses a dotted version with an optional dash qualifier.
+// Missing or malformed numbers are read as 0.
+func ParseVersion(str string) Version {
+	var v = Version{version: str}
+	numbers := str
+	if i := strings.IndexByte(str, '-'); i >= 0 {
+		numbers, v.Qualifier = str[:i], str[i+1:]
 	}
-	if n > 1 {
-		i, _ := strconv.Atoi(segments[1])
-		v.minor = uint16(i)
+	segments := strings.Split(numbers, ".")
+	fields := []*uint16{&v.Major, &v.Minor, &v.Micro, &v.Build}
+	for i, segment := range segments {
+		if i == len(fields) {
+			break
+		}
+		n, _ := strconv.Atoi(segment)
+		*fields[i] = uint16(n)
+	}
+	return v
+}
+
+// String returns the version as it was parsed, or formatted from its fields.
+func (v Version) String() string {
+	if v.version != "" {
+		return v.version
 	}
-	if n > 2 {
-		i, _ := strconv.Atoi(segments[2])
-		v.micro = uint16(i)
+	str := strconv.Itoa(int(v.Major)) + "." + strconv.Itoa(int(v.Minor)) + "." + strconv.Itoa(int(v.Micro))
+	if v.Build != 0 {
+		str += "." + strconv.Itoa(int(v.Build))
 	}
-	if n > 3 {
-		i, _ := strconv.Atoi(segments[3])
-		v.qualifier = uint16(i)
+	if v.Qualifier != "" {
+		str += "-" + v.Qualifier
 	}
-	return v
+	return str
 }
 
-func (v *Version) compare(other Version) int {
-	if v.major != other.major {
-		if (v.major - other.major) > 0 {
-			return 1
-		} else {
+// Compare returns -1, 0 or 1 if v is older than, the same as or newer than
+// other.
+func (v Version) Compare(other Version) int {
+	for _, pair := range [][2]uint16{
+		{v.Major, other.Major},
+		{v.Minor, other.Minor},
+		{v.Micro, other.Micro},
+		{v.Build, other.Build},
+	} {
+		if pair[0] < pair[1] {
 			return -1
 		}
-	}
-	if v.minor != other.minor {
-		if (v.minor - other.minor) > 0 {
+		if pair[0] > pair[1] {
 			return 1
-		} else {
-			return -1
 		}
 	}
-	if v.micro != other.micro {
-		if (v.micro - other.micro) > 0 {
-			return 1
-		} else {
-			return -1
-		}
+	return compareQualifier(v.Qualifier, other.Qualifier)
+}
+
+// AtLeast reports whether v is the same as or newer than other.
+func (v Version) AtLeast(other Version) bool {
+	return v.Compare(other) >= 0
+}
+
+// compareQualifier orders pre-releases before the release and compares a
+// trailing number numerically, so rc2 comes before rc10.
+func compareQualifier(a, b string) int {
+	switch {
+	case a == b:
+		return 0
+	case a == "":
+		return 1
+	case b == "":
+		return -1
 	}
-	if v.qualifier != other.qualifier {
-		if (v.qualifier - other.qualifier) > 0 {
-			return 1
-		} else {
+	aText, aNumber := splitQualifier(a)
+	bText, bNumber := splitQualifier(b)
+	if aText != bText {
+		if aText < bText {
 			return -1
 		}
+		return 1
+	}
+	if aNumber < bNumber {
+		return -1
+	}
+	if aNumber > bNumber {
+		return 1
 	}
 	return 0
 }
+
+func splitQualifier(q string) (text string, number int) {
+	i := len(q)
+	for i > 0 && q[i-1] >= '0' && q[i-1] <= '9' {
+		i--
+	}
+	number, _ = strconv.Atoi(q[i:])
+	return q[:i], number
+}
@@ -0,0 +1,64 @@
+package go_i2cp
+
+import "testing"
+
+func TestParseVersion(t *testing.T) {
+	for _, tc := range []struct {
+		str      string
+		expected Version
+	}{
+		{"0.9.33", Version{Major: 0, Minor: 9, Micro: 33}},
+		{"0.9.33.1", Version{Major: 0, Minor: 9, Micro: 33, Build: 1}},
+		{"0.9.50-rc1", Version{Major: 0, Minor: 9, Micro: 50, Qualifier: "rc1"}},
+		{"2.4", Version{Major: 2, Minor: 4}},
+		{"", Version{}},
+	} {
+		v := ParseVersion(tc.str)
+		if v.Compare(tc.expected) != 0 || v.Qualifier != tc.expected.Qualifier {
+			t.Fatalf("Parsing '%s': expected %+v, got %+v", tc.str, tc.expected, v)
+		}
+		if tc.str != "" && v.String() != tc.str {
+			t.Fatalf("Expected '%s' to print as itself, got '%s'", tc.str, v.String())
+		}
+	}
+}
+
+func TestVersion_Compare(t *testing.T) {
+	// each version is newer than the one before
+	ordered := []string{"0.7.1", "0.9.9", "0.9.10", "0.9.50-rc1", "0.9.50-rc2", "0.9.50-rc10", "0.9.50", "0.9.50.1", "0.10.0", "1.0.0", "2.4.0"}
+	for i := range ordered {
+		for j := range ordered {
+			expected := 0
+			if i < j {
+				expected = -1
+			} else if i > j {
+				expected = 1
+			}
+			a, b := ParseVersion(ordered[i]), ParseVersion(ordered[j])
+			if got := a.Compare(b); got != expected {
+				t.Fatalf("Comparing %s to %s: expected %d, got %d", a, b, expected, got)
+			}
+		}
+	}
+}
+
+func TestRouterInfo_Supports(t *testing.T) {
+	for _, tc := range []struct {
+		version     string
+		supported   uint32
+		unsupported uint32
+	}{
+		{"0.9.10", ROUTER_CAN_VARIABLE_LEASESET | ROUTER_CAN_SEND_MESSAGE_EXPIRES, ROUTER_CAN_HOST_LOOKUP},
+		{"0.9.33", ROUTER_CAN_HOST_LOOKUP | ROUTER_CAN_SUBSESSIONS, ROUTER_CAN_LEASESET2},
+		{"0.9.43-rc1", ROUTER_CAN_LEASESET2, ROUTER_CAN_BLINDING_INFO},
+		{"2.4.0", ROUTER_CAN_LEASESET2 | ROUTER_CAN_BLINDING_INFO, 0},
+	} {
+		info := newRouterInfo(0, tc.version)
+		if !info.Supports(tc.supported) {
+			t.Fatalf("Router %s should support %#x, has %#x", tc.version, tc.supported, info.Capabilities)
+		}
+		if tc.unsupported != 0 && info.Supports(tc.unsupported) {
+			t.Fatalf("Router %s should not support %#x", tc.version, tc.unsupported)
+		}
+	}
+}